@@ -0,0 +1,122 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccountClaim records which user a provider account is linked to. The claims table is keyed on
+// ProviderUniqueID, so a conditional put makes linking one provider account to two users impossible,
+// which scanning the users table can't guarantee.
+type AccountClaim struct {
+	ProviderUniqueID string
+	UserID           string
+	ClaimedAt        time.Time
+}
+
+// UserTag_DuplicateAccount marks users sharing a provider account with another user, see serveFlagDuplicateAccounts
+const UserTag_DuplicateAccount = "duplicate-account"
+
+func accountClaimsEnabled() bool {
+	return len(env.AccountClaimsTableName) > 0
+}
+
+// claimProviderAccount links the provider account to userID, returning ErrAccountClaimed when
+// another user already has it. Claiming an account the user already holds is a no-op.
+func claimProviderAccount(providerUniqueID, userID string) error {
+	if !accountClaimsEnabled() {
+		return nil
+	}
+	table := dynamoTable(env.AccountClaimsTableName)
+	err := table.Put(AccountClaim{ProviderUniqueID: providerUniqueID, UserID: userID, ClaimedAt: time.Now()}).
+		If("attribute_not_exists(ProviderUniqueID) OR UserID = ?", userID).
+		Run()
+	if isCondCheckFailed(err) {
+		return ErrAccountClaimed
+	}
+	return err
+}
+
+// DuplicateAccount is a provider account linked to more than one user
+type DuplicateAccount struct {
+	ProviderUniqueID string   `json:"providerUniqueId"`
+	Username         string   `json:"username"`
+	UserIDs          []string `json:"userIds"`
+}
+
+// findDuplicateAccounts scans every user for provider accounts linked more than once.
+// Records from before ProviderUniqueID existed are matched on their namespaced UniqueID.
+func findDuplicateAccounts() ([]DuplicateAccount, error) {
+	table := dynamoTable(env.DynamodbTableName)
+	iter := table.Scan().Iter()
+
+	byAccount := map[string]*DuplicateAccount{}
+	var user User
+	for iter.Next(&user) {
+		for providerName, account := range user.Accounts {
+			key := account.ProviderUniqueID
+			if len(key) == 0 {
+				key = namespacedUniqueID(providerName, account.UniqueID)
+			}
+			if _, exists := byAccount[key]; !exists {
+				byAccount[key] = &DuplicateAccount{ProviderUniqueID: key, Username: account.Username}
+			}
+			byAccount[key].UserIDs = append(byAccount[key].UserIDs, user.ID)
+		}
+		user = User{}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	duplicates := []DuplicateAccount{}
+	for _, account := range byAccount {
+		if len(account.UserIDs) > 1 {
+			sort.Strings(account.UserIDs)
+			duplicates = append(duplicates, *account)
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool { return duplicates[i].ProviderUniqueID < duplicates[j].ProviderUniqueID })
+	return duplicates, nil
+}
+
+func serveDuplicateAccounts(c *gin.Context) {
+	duplicates, err := findDuplicateAccounts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, duplicates)
+}
+
+// serveFlagDuplicateAccounts tags every user in a detected duplicate with UserTag_DuplicateAccount, for an admin
+// to review. Deciding which user keeps the account is left to the admin, nothing is merged or blocked here.
+func serveFlagDuplicateAccounts(c *gin.Context) {
+	duplicates, err := findDuplicateAccounts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	flagged := map[string]bool{}
+	for _, duplicate := range duplicates {
+		for _, userID := range duplicate.UserIDs {
+			if flagged[userID] {
+				continue
+			}
+			if err := tagUser(userID, UserTag_DuplicateAccount); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			flagged[userID] = true
+			recordAudit(c, AuditAction_FlagDuplicates, userID, map[string]string{"providerUniqueId": duplicate.ProviderUniqueID})
+		}
+	}
+
+	log.Println("admin", adminName(c), "flagged", len(flagged), "users with duplicate provider accounts")
+	c.JSON(http.StatusOK, gin.H{"duplicates": duplicates, "flaggedUsers": len(flagged)})
+}
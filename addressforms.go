@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/gin-gonic/gin"
+)
+
+// AddressForms is the ID and robust form of an address, added to responses with ?includeIdAddress=true.
+// IDAddressPending is set when the address isn't on chain yet, so it has no ID form.
+type AddressForms struct {
+	IDAddress        string `json:"idAddress,omitempty"`
+	RobustAddress    string `json:"robustAddress,omitempty"`
+	IDAddressPending bool   `json:"idAddressPending,omitempty"`
+}
+
+func wantAddressForms(c *gin.Context) bool {
+	return c.Query("includeIdAddress") == "true"
+}
+
+// lotusAddressForms resolves addr to both forms. Only account actors have a robust form, so for an ID address
+// of anything else, like a miner, RobustAddress is left empty.
+func lotusAddressForms(ctx context.Context, addr address.Address) (*AddressForms, error) {
+	lapi, closer, err := lotusGetFullNodeAPI(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	forms := &AddressForms{}
+	if addr.Protocol() != address.ID {
+		forms.RobustAddress = addr.String()
+		idAddr, err := lotusLookupIDAddress(ctx, lapi, addr)
+		if err != nil {
+			return nil, err
+		}
+		forms.IDAddress = addressStringOrEmpty(idAddr)
+		forms.IDAddressPending = idAddr == address.Undef
+		return forms, nil
+	}
+
+	forms.IDAddress = addr.String()
+	var robust address.Address
+	err = traceRPC(ctx, "StateAccountKey", func(ctx context.Context) (err error) {
+		robust, err = lapi.StateAccountKey(ctx, addr, types.EmptyTSK)
+		return err
+	})
+	if err == nil {
+		forms.RobustAddress = robust.String()
+	}
+	return forms, nil
+}
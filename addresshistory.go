@@ -0,0 +1,70 @@
+package main
+
+import "time"
+
+// VerifiedAddressChange is a new address a user had verified, in the order they switched to them
+type VerifiedAddressChange struct {
+	Address string
+	// FirstVerifiedAt is zero for an address verified before history was kept
+	FirstVerifiedAt time.Time
+}
+
+// hasVerifiedAddress reports whether the user has had addr verified before, so switching back to it isn't a change
+func (user User) hasVerifiedAddress(addr string) bool {
+	if user.MostRecentVerifiedAddress == addr {
+		return true
+	}
+	for _, change := range user.VerifiedAddressHistory {
+		if change.Address == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// lastAddressChange is when the user last had a new address verified, zero if never or unknown
+func (user User) lastAddressChange() time.Time {
+	var last time.Time
+	for _, change := range user.VerifiedAddressHistory {
+		if change.FirstVerifiedAt.After(last) {
+			last = change.FirstVerifiedAt
+		}
+	}
+	return last
+}
+
+// checkAddressChange enforces VERIFIER_MAX_ADDRESSES and VERIFIER_ADDRESS_CHANGE_COOLDOWN when addr would be a new
+// address for the user, so the reallocation cooldown can't be used to verify a fresh address every period.
+// nextEligible is set for the cooldown.
+func (user User) checkAddressChange(addr string) (nextEligible *time.Time, err error) {
+	if len(user.MostRecentVerifiedAddress) == 0 || user.hasVerifiedAddress(addr) {
+		return nil, nil
+	}
+
+	// the current address isn't in the history when it predates it
+	addresses := len(user.VerifiedAddressHistory)
+	if addresses == 0 {
+		addresses = 1
+	}
+	if env.VerifierMaxAddresses > 0 && addresses >= env.VerifierMaxAddresses {
+		return nil, ErrTooManyAddresses
+	}
+
+	if last := user.lastAddressChange(); env.VerifierAddressCooldown > 0 && !last.IsZero() {
+		if next := last.Add(env.VerifierAddressCooldown); next.After(time.Now()) {
+			return &next, ErrAddressChangeTooSoon
+		}
+	}
+	return nil, nil
+}
+
+// recordVerifiedAddress adds addr to the user's history if it's new for them
+func recordVerifiedAddress(user *User, addr string) {
+	if user.hasVerifiedAddress(addr) {
+		return
+	}
+	if len(user.VerifiedAddressHistory) == 0 && len(user.MostRecentVerifiedAddress) > 0 {
+		user.VerifiedAddressHistory = append(user.VerifiedAddressHistory, VerifiedAddressChange{Address: user.MostRecentVerifiedAddress})
+	}
+	user.VerifiedAddressHistory = append(user.VerifiedAddressHistory, VerifiedAddressChange{Address: addr, FirstVerifiedAt: time.Now()})
+}
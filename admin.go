@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// adminTokens maps each admin token to the admin's name, so admin actions can be attributed
+var adminTokens = make(map[string]string)
+
+// serviceTokens maps each internal service's token to the service's name
+var serviceTokens = make(map[string]string)
+
+func initAdminTokens() error {
+	if err := parseNamedTokens(env.AdminTokens, "ADMIN_TOKENS", "admin", adminTokens); err != nil {
+		return err
+	}
+	return parseNamedTokens(env.ServiceTokens, "SERVICE_TOKENS", "service", serviceTokens)
+}
+
+// parseNamedTokens reads comma separated name:token pairs into tokens, keyed on the token
+func parseNamedTokens(raw, envName, kind string, tokens map[string]string) error {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	for _, e := range strings.Split(raw, ",") {
+		parts := strings.SplitN(strings.TrimSpace(e), ":", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return errors.New("parsing " + envName + ": expected name:token pairs")
+		}
+		fmt.Println("Adding " + kind + " " + parts[0])
+		tokens[parts[1]] = parts[0]
+	}
+	return nil
+}
+
+// requireToken rejects requests whose header doesn't hold one of tokens, and sets key to the token's name
+func requireToken(header, key string, tokens map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.GetHeader(header)
+		for known, name := range tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+				c.Set(key, name)
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Not allowed"})
+	}
+}
+
+// requireAdmin rejects requests without a known X-Admin-Token and sets "admin" to the admin's name
+var requireAdmin = requireToken("X-Admin-Token", "admin", adminTokens)
+
+// requireService rejects requests without a known X-Service-Token and sets "service" to the service's name
+var requireService = requireToken("X-Service-Token", "service", serviceTokens)
+
+// adminName returns the name of the admin making the request, only valid behind requireAdmin
+func adminName(c *gin.Context) string {
+	return c.GetString("admin")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/gin-gonic/gin"
+)
+
+// allocationRateWindows are the periods serveAllocationRate sums over, the last one sets the projection's rate
+var allocationRateWindows = []struct {
+	Name   string
+	Length time.Duration
+}{
+	{"hour", time.Hour},
+	{"day", 24 * time.Hour},
+	{"week", 7 * 24 * time.Hour},
+}
+
+// serveAllocationRate reports how fast the verifier is handing out DataCap, from the confirmed verify transactions,
+// and when the DataCap it has available will run out if the past week's rate holds
+func serveAllocationRate(c *gin.Context) {
+	if !transactionsEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "transaction records are not enabled"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(tracedBackground(c), 30*time.Second)
+	defer cancel()
+
+	longest := allocationRateWindows[len(allocationRateWindows)-1]
+	now := time.Now()
+	txs, err := getTransactionsConfirmedSince(TransactionType_Verify, now.Add(-longest.Length))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	totals := map[string]big.Int{}
+	for _, window := range allocationRateWindows {
+		totals[window.Name] = big.Zero()
+	}
+	for _, tx := range txs {
+		amount, err := big.FromString(tx.Amount)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, window := range allocationRateWindows {
+			if tx.ConfirmedAt.After(now.Add(-window.Length)) {
+				totals[window.Name] = big.Add(totals[window.Name], amount)
+			}
+		}
+	}
+
+	dataCap, err := lotusCheckVerifierRemainingBytes(ctx, VerifierAddr.String())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	available := verifierAvailableBytes(dataCap)
+
+	type Response struct {
+		Allocated             map[string]string `json:"allocated"`
+		BytesPerHour          string            `json:"bytesPerHour"`
+		RemainingBytes        string            `json:"remainingBytes"`
+		AvailableBytes        string            `json:"availableBytes"`
+		ProjectedExhaustionAt *time.Time        `json:"projectedExhaustionAt"`
+	}
+	resp := Response{
+		Allocated:      map[string]string{},
+		RemainingBytes: dataCap.String(),
+		AvailableBytes: available.String(),
+	}
+	for name, total := range totals {
+		resp.Allocated[name] = total.String()
+	}
+
+	perHour := big.Div(totals[longest.Name], big.NewInt(int64(longest.Length/time.Hour)))
+	resp.BytesPerHour = perHour.String()
+	// nothing allocated lately means no projection, rather than one infinitely far away
+	if perHour.GreaterThan(big.Zero()) {
+		hoursLeft := big.Div(available, perHour)
+		if hoursLeft.IsInt64() && hoursLeft.Int64() < int64(100*365*24) {
+			exhaustionAt := now.Add(time.Duration(hoursLeft.Int64()) * time.Hour)
+			resp.ProjectedExhaustionAt = &exhaustionAt
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+)
+
+// AuditAction names an admin action in the audit trail
+type AuditAction string
+
+const (
+	AuditAction_ApproveReview  AuditAction = "approve_review"
+	AuditAction_RejectReview   AuditAction = "reject_review"
+	AuditAction_SetAutoTopUp   AuditAction = "set_auto_top_up"
+	AuditAction_MintUserToken  AuditAction = "mint_user_token"
+	AuditAction_SetUserTags    AuditAction = "set_user_tags"
+	AuditAction_FlagDuplicates AuditAction = "flag_duplicate_accounts"
+	AuditAction_ReloadSettings AuditAction = "reload_settings"
+)
+
+// The audit table is keyed on ID, with global secondary indexes on Actor, Action and Trail (hash), each with
+// CreatedAt (range). Trail is the same on every entry, so its index lists the whole trail in time order.
+const (
+	auditByActorIndex  = "Actor-CreatedAt-index"
+	auditByActionIndex = "Action-CreatedAt-index"
+	auditByTrailIndex  = "Trail-CreatedAt-index"
+	auditTrail         = "admin"
+)
+
+const (
+	defaultAuditPage = 50
+	maxAuditPage     = 200
+)
+
+type AuditEntry struct {
+	ID        string            `json:"id"`
+	Trail     string            `json:"-"`
+	Actor     string            `json:"actor"`
+	Action    AuditAction       `json:"action"`
+	Target    string            `json:"target"`
+	Params    map[string]string `json:"params,omitempty" dynamo:",omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+func auditEnabled() bool {
+	return len(env.DynamodbAuditTableName) > 0
+}
+
+// recordAudit adds an admin action to the audit trail. Like recordTransaction, a failure is only logged,
+// the action has already happened.
+func recordAudit(c *gin.Context, action AuditAction, target string, params map[string]string) {
+	if !auditEnabled() {
+		return
+	}
+	entry := AuditEntry{
+		ID:        uuid.New().String(),
+		Trail:     auditTrail,
+		Actor:     adminName(c),
+		Action:    action,
+		Target:    target,
+		Params:    params,
+		CreatedAt: time.Now(),
+	}
+	table := dynamoTable(env.DynamodbAuditTableName)
+	if err := table.Put(entry).Run(); err != nil {
+		log.Println("error recording audit entry:", action, target, err)
+	}
+}
+
+// getAuditEntries queries the trail newest first, using the narrowest index the filters allow.
+// after is an opaque cursor from a previous page, and the cursor to continue from is returned when there may be more.
+func getAuditEntries(actor string, action AuditAction, since time.Time, limit int64, after string) ([]AuditEntry, string, error) {
+	table := dynamoTable(env.DynamodbAuditTableName)
+
+	var query *dynamo.Query
+	switch {
+	case len(actor) > 0:
+		query = table.Get("Actor", actor).Index(auditByActorIndex)
+		if len(action) > 0 {
+			query = query.Filter("$ = ?", "Action", action)
+		}
+	case len(action) > 0:
+		query = table.Get("Action", action).Index(auditByActionIndex)
+	default:
+		query = table.Get("Trail", auditTrail).Index(auditByTrailIndex)
+	}
+	if !since.IsZero() {
+		query = query.Range("CreatedAt", dynamo.Greater, since)
+	}
+	if len(after) > 0 {
+		key, err := decodeAuditCursor(after)
+		if err != nil {
+			return nil, "", err
+		}
+		query = query.StartFrom(key)
+	}
+
+	entries := []AuditEntry{}
+	last, err := query.Order(dynamo.Descending).Limit(limit).AllWithLastEvaluatedKey(&entries)
+	if err != nil {
+		return nil, "", err
+	}
+	next := ""
+	if last != nil {
+		next = encodeAuditCursor(last)
+	}
+	return entries, next, nil
+}
+
+func encodeAuditCursor(key dynamo.PagingKey) string {
+	raw, _ := json.Marshal(key)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeAuditCursor(cursor string) (dynamo.PagingKey, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidAuditCursor
+	}
+	var key dynamo.PagingKey
+	if err := json.Unmarshal(raw, &key); err != nil {
+		return nil, ErrInvalidAuditCursor
+	}
+	return key, nil
+}
+
+// serveAuditTrail lists admin actions filtered by ?actor, ?action and ?since (RFC 3339), with ?limit and ?after for paging
+func serveAuditTrail(c *gin.Context) {
+	if !auditEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "the audit trail is not enabled"})
+		return
+	}
+
+	var since time.Time
+	if sinceStr := c.Query("since"); sinceStr != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, sinceStr); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be an RFC 3339 time"})
+			return
+		}
+	}
+
+	limit := int64(defaultAuditPage)
+	if limitStr := c.Query("limit"); limitStr != "" {
+		n, err := strconv.ParseInt(limitStr, 10, 64)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+	if limit > maxAuditPage {
+		limit = maxAuditPage
+	}
+
+	entries, next, err := getAuditEntries(c.Query("actor"), AuditAction(c.Query("action")), since, limit, c.Query("after"))
+	if err == ErrInvalidAuditCursor {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries, "next": next})
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"reflect"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	awscreds "github.com/aws/aws-sdk-go/aws/credentials"
 	awssession "github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/google/uuid"
 	"github.com/guregu/dynamo"
 )
@@ -18,35 +22,104 @@ type User struct {
 	MostRecentAllocation        time.Time
 	MostRecentDataCapCid        string
 	MostRecentVerifiedAddress   string
+	MostRecentVerifierAddress   string
 	MostRecentFaucetGrantCid    string
 	MostRecentFaucetAddress     string
+	MostRecentFaucetGrant       time.Time
 	ReceivedFaucetGrant         bool
 	Locked_Faucet               bool
 	Locked_Verifier             bool
+	Locked_User                 bool
+	// LockedAt_* are set when the lock is taken, see releaseStaleLocks
+	LockedAt_Faucet             time.Time
+	LockedAt_Verifier           time.Time
+	// AutoTopUp opts the user into scheduled top-ups, see runTopUps
+	AutoTopUp                   bool
+	// VerifiedAddressHistory lists each address the user switched to, see checkAddressChange
+	VerifiedAddressHistory      []VerifiedAddressChange
+	// Tags and AdminNote are set by admins, see serveSetUserTags. saveUser never writes them.
+	Tags                        []string
+	AdminNote                   string
 }
 
 type AccountData struct {
 	UniqueID  string    `json:"unique_id"`
+	// ProviderUniqueID is UniqueID namespaced with the provider name, see namespacedUniqueID
+	ProviderUniqueID string `json:"provider_unique_id,omitempty"`
 	Username  string    `json:"username"`
 	Name      string    `json:"name"`
+	// CreatedAt is when the account was opened with the provider, zero if the provider doesn't say.
+	// Github: created_at from /user
 	CreatedAt time.Time `json:"created_at"`
+	// Email is only set when the provider exposes one, EmailVerified is the provider's own verification flag
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified,omitempty"`
+	// ProviderMetadata holds extra provider-specific trust signals, e.g. GitHub followers
+	ProviderMetadata map[string]string `json:"provider_metadata,omitempty"`
 }
 
+// HasAccountOlderThan reports whether any linked account is at least threshold old, or the shorter age
+// AGE_EXEMPT_PROVIDERS sets for its provider. Each account's age comes from its provider's AccountCreatedAt
+// strategy, accounts it can't date count as new or old depending on UNKNOWN_ACCOUNT_AGE.
 func (user User) HasAccountOlderThan(threshold time.Duration) bool {
-	for _, account := range user.Accounts {
-		if time.Now().Sub(account.CreatedAt).Hours() >= threshold.Hours() {
+	for providerName, account := range user.Accounts {
+		minAge := threshold
+		if override, ok := env.AgeExemptProviders[providerName]; ok {
+			if override == 0 {
+				return true
+			}
+			if override < minAge {
+				minAge = override
+			}
+		}
+		createdAt, known := accountCreatedAt(providerName, account)
+		if !known {
+			if env.UnknownAccountAge == AgeFallbackOld {
+				return true
+			}
+			continue
+		}
+		if time.Now().Sub(createdAt).Hours() >= minAge.Hours() {
 			return true
 		}
 	}
 	return false
 }
 
-func dynamoTable(name string) dynamo.Table {
-	awsConfig := aws.NewConfig().
+// HasVerifiedEmailInDomains reports whether any linked account has a provider verified email in one of the domains
+func (user User) HasVerifiedEmailInDomains(domains []string) bool {
+	for _, account := range user.Accounts {
+		if !account.EmailVerified {
+			continue
+		}
+		at := strings.LastIndex(account.Email, "@")
+		if at < 0 {
+			continue
+		}
+		emailDomain := strings.ToLower(account.Email[at+1:])
+		for _, domain := range domains {
+			if emailDomain == domain {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// awsConfig is the region and credentials every AWS client here shares
+func awsConfig() *aws.Config {
+	return aws.NewConfig().
 		WithRegion(env.AWSRegion).
 		WithCredentials(awscreds.NewStaticCredentials(env.AWSAccessKey, env.AWSSecretKey, ""))
+}
+
+func dynamoTable(name string) dynamo.Table {
+	cfg := awsConfig()
+	if len(env.DynamoEndpoint) > 0 {
+		cfg = cfg.WithEndpoint(env.DynamoEndpoint)
+	}
 
-	return dynamo.New(awssession.New(), awsConfig).Table(env.DynamodbTableName)
+	return dynamo.New(awssession.New(), cfg).Table(name)
 }
 
 func getUserByID(userID string) (User, error) {
@@ -57,72 +130,171 @@ func getUserByID(userID string) (User, error) {
 	return user, err
 }
 
-func getUserWithProviderUniqueID(providerName, uniqueID string) (User, error) {
+// namespacedUniqueID prefixes a provider's ID with the provider name, so two providers
+// handing out the same raw ID can never be confused for the same account
+func namespacedUniqueID(providerName, uniqueID string) string {
+	return providerName + ":" + uniqueID
+}
+
+// getUserWithProviderUniqueID returns the matching user, or a new one (created=true) that must be saved with createUser
+func getUserWithProviderUniqueID(providerName, uniqueID string) (user User, created bool, err error) {
 	table := dynamoTable(env.DynamodbTableName)
 
-	var users []User
-	err := table.Scan().
-		Filter("Accounts."+providerName+".UniqueID = ?", uniqueID).
-		Limit(1).
-		All(&users)
+	// records created before ProviderUniqueID existed only have the raw UniqueID
+	user, found, err := scanFirstUser(table.Scan().
+		Filter("Accounts."+providerName+".ProviderUniqueID = ? OR (attribute_not_exists(Accounts."+providerName+".ProviderUniqueID) AND Accounts."+providerName+".UniqueID = ?)",
+			namespacedUniqueID(providerName, uniqueID), uniqueID))
 	if err != nil {
-		return User{}, err
+		return User{}, false, err
 	}
 
-	var user User
-	if len(users) > 0 {
-		user = users[0]
-	} else {
-		user.ID = uuid.New().String()
+	if !found {
+		// derived from the provider unique ID rather than random, so concurrent first logins race on the same key
+		user.ID = uuid.NewSHA1(uuid.NameSpaceURL, []byte(namespacedUniqueID(providerName, uniqueID))).String()
 		user.Accounts = make(map[string]AccountData)
 	}
-	return user, nil
+	return user, !found, nil
 }
 
+// createUser puts a new user only if no record has its ID yet. When a concurrent login got there first
+// the existing record is returned instead, so one person never ends up with two users.
+func createUser(user User) (User, error) {
+	table := dynamoTable(env.DynamodbTableName)
+	err := table.Put(user).If("attribute_not_exists(ID)").Run()
+	if isCondCheckFailed(err) {
+		return getUserByID(user.ID)
+	}
+	return user, err
+}
+
+// scanFirstUser pages through a filtered scan until a user matches or the table is exhausted.
+// DynamoDB applies Limit to items examined before filtering, so a limited scan can miss a match on a later page.
+func scanFirstUser(scan *dynamo.Scan) (User, bool, error) {
+	iter := scan.Iter()
+
+	var user User
+	if iter.Next(&user) {
+		return user, true, nil
+	}
+	return User{}, false, iter.Err()
+}
+
+// lockAttribute is the User attribute backing a lock
+func lockAttribute(lock UserLock) string {
+	return "Locked_" + string(lock)
+}
+
+func lockedAtAttribute(lock UserLock) string {
+	return "LockedAt_" + string(lock)
+}
+
+// lockUser acquires the lock only if it's currently false or has never been set,
+// so a concurrent second attempt fails the condition check.
+// In per-user scope the shared user lock must be acquired along with it.
 func lockUser(userID string, lock UserLock) error {
 	table := dynamoTable(env.DynamodbTableName)
-	return table.Update("ID", userID).
-		Set("Locked_"+string(lock), true).
-		If("'Locked_"+string(lock)+"' = ? OR attribute_not_exists(Locked_"+string(lock)+")", false).
-		Run()
+	update := table.Update("ID", userID).
+		Set(lockAttribute(lock), true).
+		Set(lockedAtAttribute(lock), time.Now()).
+		If("$ = ? OR attribute_not_exists($)", lockAttribute(lock), false, lockAttribute(lock))
+	if env.LockScope == PerUserLockScope {
+		update = update.
+			Set(lockAttribute(UserLock_User), true).
+			If("$ = ? OR attribute_not_exists($)", lockAttribute(UserLock_User), false, lockAttribute(UserLock_User))
+	}
+	return update.Run()
+}
+
+// isCondCheckFailed reports whether err is a conditional write's If failing
+func isCondCheckFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// isLockHeld reports whether a lockUser error is the lock's condition check failing, rather than a DynamoDB error
+func isLockHeld(err error) bool {
+	return isCondCheckFailed(err)
 }
 
 func unlockUser(userID string, lock UserLock) error {
 	table := dynamoTable(env.DynamodbTableName)
-	return table.Update("ID", userID).
-		Set("Locked_"+string(lock), false).
-		If("'Locked_"+string(lock)+"' = ?", true).
-		Run()
+	update := table.Update("ID", userID).
+		Set(lockAttribute(lock), false).
+		If("$ = ?", lockAttribute(lock), true)
+	if env.LockScope == PerUserLockScope {
+		update = update.Set(lockAttribute(UserLock_User), false)
+	}
+	return update.Run()
+}
+
+// IsLocked reports whether the operation is locked for the user, taking the lock scope into account
+func (user User) IsLocked(lock UserLock) bool {
+	if env.LockScope == PerUserLockScope && user.Locked_User {
+		return true
+	}
+	switch lock {
+	case UserLock_Verifier:
+		return user.Locked_Verifier
+	case UserLock_Faucet:
+		return user.Locked_Faucet
+	}
+	return false
+}
+
+// clearUserLock releases the lock on a user record that's about to be saved
+func clearUserLock(user *User, lock UserLock) {
+	switch lock {
+	case UserLock_Verifier:
+		user.Locked_Verifier = false
+	case UserLock_Faucet:
+		user.Locked_Faucet = false
+	}
+	if env.LockScope == PerUserLockScope {
+		user.Locked_User = false
+	}
 }
 
+// adminUserAttributes are only written by the admin tag endpoints and tagUser, with targeted updates
+var adminUserAttributes = map[string]bool{"Tags": true, "AdminNote": true}
+
+// saveUser writes every attribute of the user except adminUserAttributes. It's an Update rather than a Put,
+// which would replace the whole item and put back tags read before an admin changed them.
 func saveUser(user User) error {
 	table := dynamoTable(env.DynamodbTableName)
-	return table.Put(user).Run()
+	update := table.Update("ID", user.ID)
+
+	value := reflect.ValueOf(user)
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Name
+		if name == "ID" || adminUserAttributes[name] {
+			continue
+		}
+		// Set removes the attribute for an empty value, the same as a Put leaving it out
+		update = update.Set(name, value.Field(i).Interface())
+	}
+	return update.Run()
 }
 
 func getUserByVerifiedFilecoinAddress(filecoinAddr string) (User, error) {
 	table := dynamoTable(env.DynamodbTableName)
 
-	var users []User
-	err := table.Scan().
-		Filter("MostRecentVerifiedAddress = ?", filecoinAddr).
-		Limit(1).
-		All(&users)
+	user, found, err := scanFirstUser(table.Scan().
+		Filter("MostRecentVerifiedAddress = ?", filecoinAddr))
 	if err != nil {
 		return User{}, err
 	}
 
-	if len(users) == 0 {
+	if !found {
 		return User{}, errors.New("user not found")
 	}
-	return users[0], nil
+	return user, nil
 }
 
 func getLockedUsers(lock UserLock) ([]User, error) {
 	table := dynamoTable(env.DynamodbTableName)
 	var users []User
 	err := table.Scan().
-		Filter("Locked_"+string(lock)+" = ?", true).
+		Filter("$ = ?", lockAttribute(lock), true).
 		All(&users)
 	if err != nil {
 		var empty []User
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+)
+
+// FieldError is one problem with a request body, named by its JSON field rather than the Go struct field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func init() {
+	// report validation failures with the JSON field name
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+			if name == "-" {
+				return ""
+			}
+			if name == "" {
+				return field.Name
+			}
+			return name
+		})
+	}
+}
+
+// bindingErrors turns a ShouldBind error into per-field messages that are safe to show to users
+func bindingErrors(err error) []FieldError {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		fields := make([]FieldError, 0, len(validationErrs))
+		for _, fe := range validationErrs {
+			fields = append(fields, FieldError{Field: fe.Field(), Message: validationMessage(fe)})
+		}
+		return fields
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return []FieldError{{Field: typeErr.Field, Message: "must be a " + typeErr.Type.Kind().String()}}
+	}
+	return []FieldError{{Message: "request body must be valid JSON"}}
+}
+
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	default:
+		return "failed the " + fe.Tag() + " check"
+	}
+}
+
+// bindingErrorBody is the response for a request body that didn't bind
+func bindingErrorBody(err error) gin.H {
+	return gin.H{"error": ErrInvalidRequestBody.Error(), "fields": bindingErrors(err)}
+}
+
+// setBindingError is setError for binding failures, handleError adds the fields to the response
+func setBindingError(c *gin.Context, err error) {
+	setError(c, http.StatusBadRequest, errors.Wrap(err, "binding request JSON"))
+	c.Set("fields", bindingErrors(err))
+}
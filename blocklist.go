@@ -7,30 +7,29 @@ import (
 	"github.com/filecoin-project/go-address"
 )
 
-var blocklist = make(map[address.Address]bool)
-
-// cache the blocklist as a map in memory with faster lookups than reading the file everytime
-func initBlockListCache() error {
-	if len(env.BlockedAddresses) == 0 {
-		return nil
+// parseBlockList turns BLOCKED_ADDRESSES into a map, so lookups don't reparse it every time
+func parseBlockList(blockedAddresses string) (map[address.Address]bool, error) {
+	blocklist := make(map[address.Address]bool)
+	if len(blockedAddresses) == 0 {
+		return blocklist, nil
 	}
 
-	for _, e := range strings.Split(env.BlockedAddresses, ",") {
+	for _, e := range strings.Split(blockedAddresses, ",") {
 		fmt.Println("Adding " + e + " to blocklist.")
 		targetAddr, err := address.NewFromString(e)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		blocklist[targetAddr] = true
 	}
 
-	return nil
+	return blocklist, nil
 }
 
 func isAddressBlocked(address address.Address) bool {
-	blocked := blocklist[address]
+	blocked := settings().blocklist[address]
 	if blocked {
-		fmt.Println("Blocked address: ", address.String())
+		fmt.Println("Blocked address: ", logAddr(address.String()))
 	}
 	return blocked
 }
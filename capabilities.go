@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serveCapabilities describes what this deployment supports, so the frontend doesn't have to hardcode it.
+// Only public config belongs here - never secrets, tokens, table names or internal URLs.
+func serveCapabilities(c *gin.Context) {
+	providers := []string{}
+	for name := range oauthProviders {
+		providers = append(providers, name)
+	}
+	sort.Strings(providers)
+
+	resp := gin.H{
+		"version":        version,
+		"environment":    env.Environment,
+		"mode":           env.Mode,
+		"oauthProviders": providers,
+		"faucet":         gin.H{"enabled": env.Mode != VerifierMode},
+		"verifier":       gin.H{"enabled": env.Mode != FaucetMode},
+	}
+
+	if env.Mode != VerifierMode {
+		resp["faucet"] = gin.H{
+			"enabled":           true,
+			"address":           FaucetAddr.String(),
+			"grantSize":         settings().FaucetGrantSize.String(),
+			"firstTimeMode":     env.FaucetFirstTimeMode,
+			"rateLimit":         settings().FaucetRateLimit.String(),
+			"oneTimeOnly":       env.FaucetOneTimeOnly,
+			"minAccountAgeDays": env.FaucetMinAccountAgeDays,
+			"minerTarget":       env.FaucetMinerTarget,
+			"minMinerPower":     env.FaucetMinMinerPower.String(),
+		}
+	}
+
+	if env.Mode != FaucetMode {
+		verifier := gin.H{
+			"enabled":                    true,
+			"address":                    VerifierAddr.String(),
+			"maxAllowanceBytes":          env.MaxAllowanceBytes.String(),
+			"minAllocationBytes":         minAllocationBytes().String(),
+			"allowPartialAllocation":     env.AllowPartialAllocation,
+			"rateLimit":                  settings().VerifierRateLimit.String(),
+			"minAccountAgeDays":          env.VerifierMinAccountAgeDays,
+			"manualReviewThresholdBytes": env.ManualReviewThresholdBytes.String(),
+			"requiresTrustScore":         settings().MinTrustScore > 0,
+			"requiresProviderMetadata":   len(env.VerifierMinProviderMetadata) > 0,
+			"allowedEmailDomains":        allowedEmailDomains(),
+			"allowNewActorTargets":       env.AllowNewActorTargets,
+			"rejectVerifierTargets":      env.RejectVerifierTargets,
+		}
+		if !settings().MaxAllowancePerWindow.IsZero() {
+			verifier["maxAllowancePerWindow"] = settings().MaxAllowancePerWindow.String()
+			verifier["allowanceWindow"] = env.AllowanceWindow.String()
+		}
+		resp["verifier"] = verifier
+	}
+
+	// only changes on redeploy
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, resp)
+}
+
+// environmentHeader tags every response with the deployment it came from, so staging and production
+// can't be mistaken for each other
+func environmentHeader(c *gin.Context) {
+	c.Header("X-Environment", env.Environment)
+	c.Next()
+}
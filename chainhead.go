@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/gin-gonic/gin"
+)
+
+// A fraction of the 30s block time, so the headers never trail the node by more than a few seconds
+const chainHeadCacheTTL = 5 * time.Second
+
+type chainHead struct {
+	Height int64
+	Time   time.Time
+}
+
+var (
+	cachedChainHead   chainHead
+	cachedChainHeadAt time.Time
+	cachedChainHeadMu sync.Mutex
+)
+
+// lotusChainHead returns the node's current head, cached briefly so read endpoints don't each cost a ChainHead call
+func lotusChainHead(ctx context.Context) (chainHead, error) {
+	cachedChainHeadMu.Lock()
+	defer cachedChainHeadMu.Unlock()
+
+	if time.Since(cachedChainHeadAt) < chainHeadCacheTTL {
+		return cachedChainHead, nil
+	}
+
+	api, closer, err := lotusGetFullNodeAPI(ctx)
+	if err != nil {
+		return chainHead{}, err
+	}
+	defer closer()
+
+	var ts *types.TipSet
+	err = traceRPC(ctx, "ChainHead", func(ctx context.Context) (err error) {
+		ts, err = api.ChainHead(ctx)
+		return err
+	})
+	if err != nil {
+		return chainHead{}, err
+	}
+
+	cachedChainHead = chainHead{
+		Height: int64(ts.Height()),
+		Time:   time.Unix(int64(ts.MinTimestamp()), 0).UTC(),
+	}
+	cachedChainHeadAt = time.Now()
+	return cachedChainHead, nil
+}
+
+// lastChainHead returns the head lotusChainHead last fetched, without calling the node, if it's no older than maxAge
+func lastChainHead(maxAge time.Duration) (chainHead, bool) {
+	cachedChainHeadMu.Lock()
+	defer cachedChainHeadMu.Unlock()
+
+	if cachedChainHeadAt.IsZero() || time.Since(cachedChainHeadAt) > maxAge {
+		return chainHead{}, false
+	}
+	return cachedChainHead, true
+}
+
+// chainHeadHeaders adds X-Chain-Height and X-Chain-Time to state-reading responses, so clients can tell
+// which chain state an answer reflects and spot a lagging node. A failed lookup just leaves them off.
+func chainHeadHeaders(c *gin.Context) {
+	if !env.ChainHeadHeaders {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(tracedBackground(c), 5*time.Second)
+	defer cancel()
+
+	head, err := lotusChainHead(ctx)
+	if err != nil {
+		log.Println("error getting chain head for response headers:", err)
+		return
+	}
+	c.Header("X-Chain-Height", fmt.Sprint(head.Height))
+	c.Header("X-Chain-Time", head.Time.Format(time.RFC3339))
+}
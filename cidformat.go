@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/ipfs/go-cid"
+	mbase "github.com/multiformats/go-multibase"
+	"github.com/pkg/errors"
+)
+
+// Message CIDs in responses are always the base32 CIDv1 string Lotus prints and explorers search on.
+// CID_MULTIBASE (e.g. "base58btc") adds a cidEncoded field in that multibase for tools that expect one.
+var cidResponseEncoder *mbase.Encoder
+
+func initCidResponseEncoding() error {
+	if len(env.CidMultibase) == 0 {
+		return nil
+	}
+	enc, err := mbase.EncoderByName(env.CidMultibase)
+	if err != nil {
+		return errors.Wrap(err, "parsing CID_MULTIBASE")
+	}
+	cidResponseEncoder = &enc
+	return nil
+}
+
+// encodeCidForResponse returns the CID in CID_MULTIBASE, or "" when it isn't configured
+func encodeCidForResponse(c cid.Cid) string {
+	if cidResponseEncoder == nil {
+		return ""
+	}
+	return c.Encode(*cidResponseEncoder)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// The head tipset's timestamp is when its epoch started, so on a healthy node it trails the wall clock
+// by up to one block time without any skew
+const blockTime = 30 * time.Second
+
+// checkClockSkew compares the local clock with the chain head's timestamp and warns when they've drifted
+// apart by more than MAX_CLOCK_SKEW. A node that's fallen behind the chain looks the same as a fast clock,
+// so the message says both.
+func checkClockSkew() {
+	ctx, cancel := context.WithTimeout(backgroundCtx, 30*time.Second)
+	defer cancel()
+
+	head, err := lotusChainHead(ctx)
+	if err != nil {
+		log.Println("error getting chain head for the clock skew check:", err)
+		return
+	}
+
+	// positive when the local clock is ahead of the chain
+	skew := time.Since(head.Time)
+	if skew > 0 {
+		skew -= blockTime
+		if skew < 0 {
+			skew = 0
+		}
+	}
+	clockSkewSeconds.Set(int64(skew.Seconds()))
+
+	if skew > env.MaxClockSkew || -skew > env.MaxClockSkew {
+		message := "CLOCK SKEW: local time is " + skew.String() + " off the chain head's " + head.Time.Format(time.RFC3339) +
+			", either the clock has drifted or the node is behind"
+		log.Println(message)
+		sendSlackMessage(message)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// bindCorrelationID reads the optional {"correlationId": ...} body of a verify or faucet request. It's an
+// integrator's own ID for the request, never interpreted here, only echoed back in the response, stored on
+// the transaction record and included in webhooks so asynchronous confirmations can be matched up.
+func bindCorrelationID(c *gin.Context) (string, error) {
+	type Request struct {
+		CorrelationID string `json:"correlationId" binding:"max=128"`
+	}
+
+	var body Request
+	if c.Request.ContentLength == 0 {
+		return "", nil
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		return "", err
+	}
+	return body.CorrelationID, nil
+}
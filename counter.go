@@ -16,10 +16,11 @@ func initRedis() *redis.Client {
 }
 
 func initCounter(ctx context.Context) error {
-	if env.MaxTotalAllocations == 0 {
+	// set even when the counter is off, MAX_TOTAL_ALLOCATIONS can be turned on by a reload
+	partitionKey = env.DynamodbTableName + ":COUNT"
+	if settings().MaxTotalAllocations == 0 {
 		return nil
 	}
-	partitionKey = env.DynamodbTableName + ":COUNT"
 	rdb := initRedis()
 
 	_, err := rdb.Get(ctx, partitionKey).Result()
@@ -35,20 +36,24 @@ func initCounter(ctx context.Context) error {
 }
 
 func getCount(ctx context.Context) (uint, error) {
-	if env.MaxTotalAllocations == 0 {
+	if settings().MaxTotalAllocations == 0 {
 		return 0, nil
 	}
 	rdb := initRedis()
 	val, err := rdb.Get(ctx, partitionKey).Uint64()
+	if err == redis.Nil {
+		// never set, the counter was off at startup
+		return 0, nil
+	}
 	if err != nil {
 		// if theres an error, just return the count so no allocations get granted
-		return env.MaxTotalAllocations, err
+		return settings().MaxTotalAllocations, err
 	}
 	return uint(val), nil
 }
 
 func reachedCounter(ctx context.Context) (bool, error) {
-	if env.MaxTotalAllocations == 0 {
+	if settings().MaxTotalAllocations == 0 {
 		return false, nil
 	}
 
@@ -57,11 +62,11 @@ func reachedCounter(ctx context.Context) (bool, error) {
 		return true, err
 	}
 
-	return val >= env.MaxTotalAllocations, nil
+	return val >= settings().MaxTotalAllocations, nil
 }
 
 func incrementCounter(ctx context.Context) error {
-	if env.MaxTotalAllocations == 0 {
+	if settings().MaxTotalAllocations == 0 {
 		return nil
 	}
 	rdb := initRedis()
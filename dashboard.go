@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/gin-gonic/gin"
+)
+
+// dashboardSectionTimeout bounds each section of /admin/dashboard, one that runs over is reported as an error
+// rather than holding up the rest
+const dashboardSectionTimeout = 10 * time.Second
+
+// dashboardRecentFailures is how many of the last day's failed transactions the dashboard lists
+const dashboardRecentFailures = 10
+
+// dashboardSyncedLag is how far the node's head can trail the wall clock before it's reported as not synced
+const dashboardSyncedLag = 5 * time.Minute
+
+var (
+	cachedDashboard   gin.H
+	cachedDashboardAt time.Time
+	cachedDashboardMu sync.Mutex
+)
+
+type dashboardSection struct {
+	name string
+	load func(ctx context.Context) (interface{}, error)
+}
+
+// dashboardSections are the parts of /admin/dashboard. Ones that don't apply to the mode or configuration are left out.
+func dashboardSections() []dashboardSection {
+	sections := []dashboardSection{
+		{"node", dashboardNode},
+		{"lockedUsers", dashboardLockedUsers},
+	}
+	if env.Mode != FaucetMode {
+		sections = append(sections, dashboardSection{"verifier", dashboardVerifier})
+	}
+	if env.Mode != VerifierMode {
+		sections = append(sections, dashboardSection{"faucet", dashboardFaucet})
+	}
+	if transactionsEnabled() {
+		sections = append(sections,
+			dashboardSection{"pendingMessages", dashboardPendingMessages},
+			dashboardSection{"recentFailures", dashboardFailures},
+		)
+	}
+	return sections
+}
+
+// serveAdminDashboard gathers the operational views into the one response the ops page needs. Sections load
+// concurrently and each fails on its own, as {"error": ...}, so a slow node or table only blanks its own part.
+// The result is reused for ADMIN_DASHBOARD_CACHE_TTL, and concurrent requests wait for one load rather than each starting one.
+func serveAdminDashboard(c *gin.Context) {
+	cachedDashboardMu.Lock()
+	defer cachedDashboardMu.Unlock()
+
+	if cachedDashboard != nil && time.Since(cachedDashboardAt) < env.AdminDashboardCacheTTL {
+		c.JSON(http.StatusOK, cachedDashboard)
+		return
+	}
+
+	sections := dashboardSections()
+	results := make([]interface{}, len(sections))
+	var wg sync.WaitGroup
+	for i, section := range sections {
+		wg.Add(1)
+		go func(i int, section dashboardSection) {
+			defer wg.Done()
+			results[i] = loadDashboardSection(tracedBackground(c), section)
+		}(i, section)
+	}
+	wg.Wait()
+
+	resp := gin.H{"generatedAt": time.Now().UTC()}
+	for i, section := range sections {
+		resp[section.name] = results[i]
+	}
+	cachedDashboard, cachedDashboardAt = resp, time.Now()
+	c.JSON(http.StatusOK, resp)
+}
+
+// loadDashboardSection gives up on the section at dashboardSectionTimeout even if its source ignores the context,
+// as DynamoDB calls do, leaving it to finish in the background
+func loadDashboardSection(ctx context.Context, section dashboardSection) interface{} {
+	ctx, cancel := context.WithTimeout(ctx, dashboardSectionTimeout)
+	defer cancel()
+
+	type result struct {
+		value interface{}
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := section.load(ctx)
+		done <- result{value, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return gin.H{"error": r.err.Error()}
+		}
+		return r.value
+	case <-ctx.Done():
+		return gin.H{"error": "timed out after " + dashboardSectionTimeout.String()}
+	}
+}
+
+func dashboardNode(ctx context.Context) (interface{}, error) {
+	head, err := lotusChainHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lag := time.Since(head.Time)
+	return gin.H{
+		"height": head.Height,
+		"time":   head.Time,
+		"lag":    lag.Round(time.Second).String(),
+		"synced": lag < dashboardSyncedLag,
+	}, nil
+}
+
+func dashboardVerifier(ctx context.Context) (interface{}, error) {
+	dataCap, err := lotusCheckVerifierRemainingBytes(ctx, VerifierAddr.String())
+	if err != nil {
+		return nil, err
+	}
+	resp := gin.H{
+		"address":        VerifierAddr.String(),
+		"remainingBytes": dataCap.String(),
+		"availableBytes": verifierAvailableBytes(dataCap).String(),
+	}
+	if settings().MaxTotalAllocations > 0 {
+		count, err := getCount(ctx)
+		if err != nil {
+			return nil, err
+		}
+		resp["allocations"] = gin.H{"count": count, "max": settings().MaxTotalAllocations}
+	}
+	return resp, nil
+}
+
+func dashboardFaucet(ctx context.Context) (interface{}, error) {
+	balance, err := lotusWalletBalance(ctx, FaucetAddr)
+	if err != nil {
+		return nil, err
+	}
+	resp := gin.H{"address": FaucetAddr.String(), "balance": types.FIL(balance)}
+	if transactionsEnabled() {
+		_, window, err := getFaucetTotals(ctx, time.Now().Add(-env.FaucetStatsWindow))
+		if err != nil {
+			return nil, err
+		}
+		resp["window"] = window
+		resp["windowDuration"] = env.FaucetStatsWindow.String()
+	}
+	return resp, nil
+}
+
+func dashboardLockedUsers(ctx context.Context) (interface{}, error) {
+	resp := gin.H{}
+	for _, lock := range []UserLock{UserLock_Verifier, UserLock_Faucet} {
+		users, err := getLockedUsers(lock)
+		if err != nil {
+			return nil, err
+		}
+		resp[string(lock)] = len(users)
+	}
+	return resp, nil
+}
+
+func dashboardPendingMessages(ctx context.Context) (interface{}, error) {
+	txs, err := getTransactionsWithStatus(TransactionStatus_Pending, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	byType := map[TransactionType]int{}
+	for _, tx := range txs {
+		byType[tx.Type]++
+	}
+	return gin.H{"total": len(txs), "byType": byType}, nil
+}
+
+func dashboardFailures(ctx context.Context) (interface{}, error) {
+	txs, err := getTransactionsWithStatus(TransactionStatus_Failed, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(txs, func(i, j int) bool { return txs[i].CreatedAt.After(txs[j].CreatedAt) })
+	if len(txs) > dashboardRecentFailures {
+		txs = txs[:dashboardRecentFailures]
+	}
+	return txs, nil
+}
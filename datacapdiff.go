@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// dataCapDelta is one verified client whose DataCap differs between the two tipsets.
+// Before or After is zero when the client was added or removed in between.
+type dataCapDelta struct {
+	Address address.Address `json:"address"`
+	Before  big.Int         `json:"before"`
+	After   big.Int         `json:"after"`
+	Delta   big.Int         `json:"delta"`
+}
+
+func lotusTipSetKeyAtHeight(ctx context.Context, height abi.ChainEpoch) (types.TipSetKey, error) {
+	api, closer, err := lotusGetFullNodeAPI(ctx)
+	if err != nil {
+		return types.EmptyTSK, err
+	}
+	defer closer()
+
+	var ts *types.TipSet
+	err = traceRPC(ctx, "ChainGetTipSetByHeight", func(ctx context.Context) (err error) {
+		ts, err = api.ChainGetTipSetByHeight(ctx, height, types.EmptyTSK)
+		return err
+	})
+	if err != nil {
+		return types.EmptyTSK, err
+	}
+	return ts.Key(), nil
+}
+
+// lotusDiffVerifiedClients calls fn for every client whose DataCap changed between the tipsets. The earlier
+// client list is held in memory, the later one is streamed against it.
+func lotusDiffVerifiedClients(ctx context.Context, from, to types.TipSetKey, fn func(dataCapDelta) error) error {
+	before := map[address.Address]big.Int{}
+	err := lotusWalkVerifiedClientsAt(ctx, from, func(client addrAndDataCap) error {
+		before[client.Address] = client.DataCap
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "loading verified clients at the first tipset")
+	}
+
+	err = lotusWalkVerifiedClientsAt(ctx, to, func(client addrAndDataCap) error {
+		prev, existed := before[client.Address]
+		delete(before, client.Address)
+		if !existed {
+			prev = big.Zero()
+		} else if prev.Equals(client.DataCap) {
+			return nil
+		}
+		return fn(dataCapDelta{client.Address, prev, client.DataCap, big.Sub(client.DataCap, prev)})
+	})
+	if err != nil {
+		return errors.Wrap(err, "loading verified clients at the second tipset")
+	}
+
+	// whatever's left was removed, which is what happens once a client's DataCap is all used
+	for addr, prev := range before {
+		if err := fn(dataCapDelta{addr, prev, big.Zero(), prev.Neg()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errDiffTruncated = errors.New("diff truncated")
+
+// serveVerifiedClientsDiff streams, as ndjson, the verified clients whose DataCap changed between heights ?from and ?to.
+// At most MAX_DATACAP_DIFF_ENTRIES are written, with a final {"truncated":true} line when there were more.
+// The node has to still hold state for both heights, which a pruned node won't for old ones.
+func serveVerifiedClientsDiff(c *gin.Context) {
+	from, errFrom := strconv.ParseInt(c.Query("from"), 10, 64)
+	to, errTo := strconv.ParseInt(c.Query("to"), 10, 64)
+	if errFrom != nil || errTo != nil || from < 0 || to <= from {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be chain heights with from < to"})
+		return
+	}
+
+	// rooted in the request context so a client disconnect aborts the walks
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Minute)
+	defer cancel()
+
+	fromKey, err := lotusTipSetKeyAtHeight(ctx, abi.ChainEpoch(from))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.Wrap(err, "loading the from tipset").Error()})
+		return
+	}
+	toKey, err := lotusTipSetKeyAtHeight(ctx, abi.ChainEpoch(to))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errors.Wrap(err, "loading the to tipset").Error()})
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+
+	enc := json.NewEncoder(c.Writer)
+	written := 0
+	err = lotusDiffVerifiedClients(ctx, fromKey, toKey, func(delta dataCapDelta) error {
+		if env.MaxDataCapDiffEntries > 0 && written >= env.MaxDataCapDiffEntries {
+			return errDiffTruncated
+		}
+		if err := enc.Encode(delta); err != nil {
+			return err
+		}
+		written++
+		c.Writer.Flush()
+		return nil
+	})
+	if errors.Cause(err) == errDiffTruncated {
+		enc.Encode(gin.H{"truncated": true})
+	} else if err != nil {
+		// the status is already written, all we can do is stop and log
+		log.Println("error streaming verified client diff:", err)
+	}
+}
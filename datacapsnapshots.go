@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/gin-gonic/gin"
+	"github.com/guregu/dynamo"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Every DATACAP_SNAPSHOT_SCHEDULE the remaining DataCap of each user's most recently verified address is sampled.
+// The snapshots table is keyed on Address (hash) and SampledAt (range), so a client's samples read back in time order.
+
+type DataCapSnapshot struct {
+	Address        string    `json:"-"`
+	SampledAt      time.Time `json:"sampledAt"`
+	RemainingBytes string    `json:"remainingBytes"`
+}
+
+func dataCapSnapshotsEnabled() bool {
+	return len(env.DynamodbSnapshotsTableName) > 0 && len(env.DataCapSnapshotSchedule) > 0
+}
+
+func getVerifiedUsers() ([]User, error) {
+	table := dynamoTable(env.DynamodbTableName)
+	var users []User
+	err := table.Scan().
+		Filter("attribute_exists(MostRecentVerifiedAddress) AND MostRecentVerifiedAddress <> ?", "").
+		All(&users)
+	if err != nil {
+		var empty []User
+		return empty, err
+	}
+	return users, nil
+}
+
+func snapshotDataCaps() {
+	ctx, span := startSpan(backgroundCtx, "snapshotDataCaps", trace.SpanKindInternal)
+	defer span.End()
+
+	users, err := getVerifiedUsers()
+	if err != nil {
+		sendSlackMessage(err.Error() + "error getting verified users")
+		return
+	}
+
+	table := dynamoTable(env.DynamodbSnapshotsTableName)
+	now := time.Now()
+	for _, user := range users {
+		remaining, err := lotusCheckAccountRemainingBytes(ctx, user.MostRecentVerifiedAddress)
+		if err != nil {
+			log.Println("datacap snapshot skipped for", logAddr(user.MostRecentVerifiedAddress)+":", err)
+			continue
+		}
+		snapshot := DataCapSnapshot{
+			Address:        user.MostRecentVerifiedAddress,
+			SampledAt:      now,
+			RemainingBytes: remaining.String(),
+		}
+		if err := table.Put(snapshot).Run(); err != nil {
+			log.Println("error saving datacap snapshot for", logAddr(user.MostRecentVerifiedAddress)+":", err)
+		}
+	}
+}
+
+func getDataCapSnapshots(addr string, since time.Time) ([]DataCapSnapshot, error) {
+	table := dynamoTable(env.DynamodbSnapshotsTableName)
+	var snapshots []DataCapSnapshot
+	err := table.Get("Address", addr).
+		Range("SampledAt", dynamo.Greater, since).
+		All(&snapshots)
+	if err != nil {
+		var empty []DataCapSnapshot
+		return empty, err
+	}
+	return snapshots, nil
+}
+
+// dataCapConsumption is how many bytes the samples show being used up and over how long. Only drops between
+// consecutive samples count, a rise is a new allocation and says nothing about how fast the client uses DataCap.
+func dataCapConsumption(snapshots []DataCapSnapshot) (big.Int, time.Duration, error) {
+	used := big.Zero()
+	if len(snapshots) < 2 {
+		return used, 0, nil
+	}
+	previous, err := big.FromString(snapshots[0].RemainingBytes)
+	if err != nil {
+		return used, 0, err
+	}
+	for _, snapshot := range snapshots[1:] {
+		remaining, err := big.FromString(snapshot.RemainingBytes)
+		if err != nil {
+			return used, 0, err
+		}
+		if remaining.LessThan(previous) {
+			used = big.Add(used, big.Sub(previous, remaining))
+		}
+		previous = remaining
+	}
+	return used, snapshots[len(snapshots)-1].SampledAt.Sub(snapshots[0].SampledAt), nil
+}
+
+// serveDataCapProjection projects when a client will run out of DataCap, and so when they're likely to ask for
+// more, from the snapshots taken over the last DATACAP_PROJECTION_WINDOW
+func serveDataCapProjection(c *gin.Context) {
+	if !dataCapSnapshotsEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "datacap snapshots are not enabled"})
+		return
+	}
+
+	targetAddr, err := address.NewFromString(c.Param("target_addr"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(tracedBackground(c), 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	snapshots, err := getDataCapSnapshots(targetAddr.String(), now.Add(-env.DataCapProjectionWindow))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	used, span, err := dataCapConsumption(snapshots)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	remaining, err := lotusCheckAccountRemainingBytes(ctx, targetAddr.String())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	type Response struct {
+		Address               string            `json:"address"`
+		RemainingBytes        string            `json:"remainingBytes"`
+		BytesPerHour          string            `json:"bytesPerHour"`
+		ProjectedExhaustionAt *time.Time        `json:"projectedExhaustionAt"`
+		Samples               []DataCapSnapshot `json:"samples"`
+	}
+	resp := Response{
+		Address:        targetAddr.String(),
+		RemainingBytes: remaining.String(),
+		BytesPerHour:   "0",
+		Samples:        snapshots,
+	}
+	if resp.Samples == nil {
+		resp.Samples = []DataCapSnapshot{}
+	}
+
+	// under an hour of samples is too little to call a rate
+	if hours := int64(span / time.Hour); hours > 0 {
+		perHour := big.Div(used, big.NewInt(hours))
+		resp.BytesPerHour = perHour.String()
+		// no consumption lately means no projection, same as serveAllocationRate
+		if perHour.GreaterThan(big.Zero()) {
+			hoursLeft := big.Div(remaining, perHour)
+			if hoursLeft.IsInt64() && hoursLeft.Int64() < int64(100*365*24) {
+				exhaustionAt := now.Add(time.Duration(hoursLeft.Int64()) * time.Hour)
+				resp.ProjectedExhaustionAt = &exhaustionAt
+			}
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
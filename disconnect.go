@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// statusClientClosedRequest is nginx's 499, nobody reads it but it keeps disconnects apart from our own failures
+const statusClientClosedRequest = 499
+
+// clientDisconnected reports whether the client went away mid-request. Handlers mostly work on tracedBackground,
+// which outlives the request on purpose, so this looks at the request's own context.
+func clientDisconnected(c *gin.Context) bool {
+	return c.Request != nil && c.Request.Context().Err() == context.Canceled
+}
+
+// isCanceled reports whether err is a cancellation, a client disconnect or shutdown, rather than
+// context.DeadlineExceeded from one of our own timeouts
+func isCanceled(err error) bool {
+	return errors.Cause(err) == context.Canceled
+}
+
+// abortDisconnected stops a handler before it starts expensive work for a client that's no longer there
+func abortDisconnected(c *gin.Context) {
+	c.AbortWithStatus(statusClientClosedRequest)
+}
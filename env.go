@@ -1,7 +1,11 @@
 package main
 
 import (
+	"fmt"
+	gobig "math/big"
 	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
 	envpkg "github.com/caarlos0/env"
@@ -19,41 +23,293 @@ const (
 	VerifierMode Mode = "VERIFIER"
 )
 
+// LockScope decides whether user locks are held per operation or shared across all operations
+type LockScope string
+const (
+	// PerOperationLockScope lets a user run a verify and a faucet request at the same time
+	PerOperationLockScope LockScope = "per-operation"
+	// PerUserLockScope allows only one operation per user at a time
+	PerUserLockScope LockScope = "per-user"
+)
+
+// MinerTarget picks which of a miner's addresses the faucet funds
+type MinerTarget string
+const (
+	// MinerTargetActor funds the miner actor itself
+	MinerTargetActor MinerTarget = "actor"
+	// MinerTargetWorker funds the worker, which pays for PoSt messages
+	MinerTargetWorker MinerTarget = "worker"
+	// MinerTargetOwner funds the owner
+	MinerTargetOwner MinerTarget = "owner"
+)
+
+// AgeFallback decides how the account age checks treat an account whose provider doesn't report a creation date
+type AgeFallback string
+
+const (
+	// AgeFallbackNew fails the age check
+	AgeFallbackNew AgeFallback = "treat-as-new"
+	// AgeFallbackOld passes the age check
+	AgeFallbackOld AgeFallback = "treat-as-old"
+)
+
+// ProviderAgeOverrides maps a provider name to the account age its accounts are held to instead of the
+// configured minimum, 0 exempting them from the age check entirely. Parsed from "github:30,corp-sso".
+type ProviderAgeOverrides map[string]time.Duration
+
+func parseProviderAgeOverrides(v string) (ProviderAgeOverrides, error) {
+	overrides := ProviderAgeOverrides{}
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, days := entry, "0"
+		if i := strings.Index(entry, ":"); i >= 0 {
+			name, days = entry[:i], entry[i+1:]
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(days), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid account age %q for provider %q", days, name)
+		}
+		overrides[strings.TrimSpace(name)] = time.Duration(n) * 24 * time.Hour
+	}
+	return overrides, nil
+}
+
 // Env exports
 type Env struct {
 	Port                      string          `env:"PORT" envDefault:"8080"`
 	JWTSecret                 string          `env:"JWT_SECRET,required"`
+	// total retrying allowed per request across all lotus calls, by time and by attempts. 0 leaves either unbounded
+	RetryBudget               time.Duration   `env:"RETRY_BUDGET" envDefault:"0"`
+	RetryBudgetAttempts       int             `env:"RETRY_BUDGET_ATTEMPTS" envDefault:"0"`
+	// tolerance on JWT exp and nbf, and how far the clock may drift from the chain before warning. 0 turns the check off
+	JWTLeeway                 time.Duration   `env:"JWT_LEEWAY" envDefault:"30s"`
+	MaxClockSkew              time.Duration   `env:"MAX_CLOCK_SKEW" envDefault:"1m"`
 	AWSRegion                 string          `env:"AWS_REGION" envDefault:"us-east-1"`
 	AWSAccessKey              string          `env:"AWS_ACCESS_KEY,required"`
 	AWSSecretKey              string          `env:"AWS_SECRET_KEY,required"`
 	DynamodbTableName         string          `env:"DYNAMODB_TABLE_NAME,required"`
+	// points DynamoDB at e.g. DynamoDB Local or LocalStack, the AWS keys can be dummy values then
+	DynamoEndpoint            string          `env:"DYNAMO_ENDPOINT"`
+	// keyed on ID, used for allocations held for manual review
+	DynamodbReviewsTableName  string          `env:"DYNAMODB_REVIEWS_TABLE_NAME"`
+	// review decisions are POSTed here as JSON so users can be notified
+	ReviewWebhookURL          string          `env:"REVIEW_WEBHOOK_URL"`
+	// shared secret for the X-Signature header on outbound webhooks, see webhook.go
+	WebhookSigningSecret      string          `env:"WEBHOOK_SIGNING_SECRET"`
+	// comma separated URLs notified of every confirmed allocation, handles are the user's provider usernames
+	AllocationWebhookURLs     string          `env:"ALLOCATION_WEBHOOK_URLS"`
+	AllocationNotifyPII       bool            `env:"ALLOCATION_NOTIFY_PII" envDefault:"false"`
+	// comma separated name:token pairs, admins send their token in the X-Admin-Token header
+	AdminTokens               string          `env:"ADMIN_TOKENS"`
+	// internal services allowed to introspect user JWTs, same format as ADMIN_TOKENS in an X-Service-Token header
+	ServiceTokens             string          `env:"SERVICE_TOKENS"`
+	// transaction records are only kept when this is set, see transactions.go for the table layout
+	DynamodbTransactionsTableName string      `env:"DYNAMODB_TRANSACTIONS_TABLE_NAME"`
+	// keyed on ProviderUniqueID, when set a provider account can only ever be linked to one user, see AccountClaim
+	AccountClaimsTableName    string          `env:"DYNAMODB_ACCOUNT_CLAIMS_TABLE_NAME"`
+	// admin actions are only recorded when this is set, see audit.go for the table layout
+	DynamodbAuditTableName    string          `env:"DYNAMODB_AUDIT_TABLE_NAME"`
+	// remaining DataCap samples for GET /verify/projection/:target_addr, see datacapsnapshots.go for the table layout
+	DynamodbSnapshotsTableName string         `env:"DYNAMODB_DATACAP_SNAPSHOTS_TABLE_NAME"`
+	// confirmed allocation receipts are archived to this bucket when it's set, see receiptarchive.go
+	ReceiptS3Bucket           string          `env:"RECEIPT_S3_BUCKET"`
+	ReceiptS3Prefix           string          `env:"RECEIPT_S3_PREFIX"`
 	LotusAPIDialAddr          string          `env:"LOTUS_API_DIAL_ADDR,required"`
 	LotusAPIToken             string          `env:"LOTUS_API_TOKEN,required"`
 	BlockedAddresses          string          `env:"BLOCKED_ADDRESSES"`
+	// KEY=VALUE file read on SIGHUP or POST /admin/reload, only the settings listed on runtimeSettings are reloaded
+	EnvReloadFile             string          `env:"ENV_RELOAD_FILE"`
 	GithubClientID            string          `env:"GITHUB_CLIENT_ID,required"`
 	GithubClientSecret        string          `env:"GITHUB_CLIENT_SECRET,required"`
+	// concurrent token exchanges per provider, OAUTH_CONCURRENCY_LIMITS overrides it with provider=n pairs
+	OAuthConcurrency          int             `env:"OAUTH_CONCURRENCY" envDefault:"10"`
+	OAuthConcurrencyLimits    string          `env:"OAUTH_CONCURRENCY_LIMITS"`
+	OAuthQueueTimeout         time.Duration   `env:"OAUTH_QUEUE_TIMEOUT" envDefault:"2s"`
+	// verify messages submitted to the node at once, 0 disables the submission queue
+	VerifySubmitConcurrency   int             `env:"VERIFY_SUBMIT_CONCURRENCY" envDefault:"0"`
+	VerifyQueueSize           int             `env:"VERIFY_QUEUE_SIZE" envDefault:"50"`
+	VerifyQueueTimeout        time.Duration   `env:"VERIFY_QUEUE_TIMEOUT" envDefault:"30s"`
+	VerifyQueueRetryAfter     time.Duration   `env:"VERIFY_QUEUE_RETRY_AFTER" envDefault:"30s"`
 	MaxFee                    types.FIL       `env:"MAX_FEE" envDefault:"0afil"`
+	// floor for the estimated gas premium per unit of gas, capped by MAX_FEE. 0 is off
+	MinGasPremium             types.FIL       `env:"MIN_GAS_PREMIUM" envDefault:"0afil"`
 	Mode                      Mode            `env:"MODE"`
+	// which deployment this is, e.g. "staging". Sent as X-Environment on every response and in /capabilities
+	Environment               string          `env:"ENVIRONMENT" envDefault:"production"`
+	// comma separated monitor names that shouldn't run, e.g. "top-up,reconcile-faucet"
+	DisabledMonitors          string          `env:"DISABLED_MONITORS"`
+	// how long in-flight requests get to finish on SIGTERM before background work is cancelled
+	ShutdownTimeout           time.Duration   `env:"SHUTDOWN_TIMEOUT" envDefault:"30s"`
+	// verify, faucet and review approvals answer 503 until the startup checks pass or this runs out. 0 skips them
+	WarmUpTimeout             time.Duration   `env:"WARM_UP_TIMEOUT" envDefault:"2m"`
+	// multibase name, e.g. base58btc, for an extra cidEncoded field next to the base32 cid in responses
+	CidMultibase              string          `env:"CID_MULTIBASE"`
+	// add X-Chain-Height and X-Chain-Time headers to responses read from chain state
+	ChainHeadHeaders          bool            `env:"CHAIN_HEAD_HEADERS" envDefault:"false"`
+	// gzip responses of at least COMPRESSION_MIN_BYTES for clients that accept it
+	EnableCompression         bool            `env:"ENABLE_COMPRESSION" envDefault:"false"`
+	CompressionMinBytes       int             `env:"COMPRESSION_MIN_BYTES" envDefault:"1024"`
+	// wrap JSON responses as {data, error, meta}, see envelopeResponses
+	ResponseEnvelope          bool            `env:"RESPONSE_ENVELOPE" envDefault:"false"`
+	// fraction of successful requests logged, between 0 and 1. Failed requests are always logged
+	LogSampleRate             float64         `env:"LOG_SAMPLE_RATE" envDefault:"1"`
+	// log requests the client abandoned as failures, off by default since they're noise rather than our errors
+	LogClientDisconnects      bool            `env:"LOG_CLIENT_DISCONNECTS" envDefault:"false"`
+	// how long /admin/dashboard reuses its last result
+	AdminDashboardCacheTTL    time.Duration   `env:"ADMIN_DASHBOARD_CACHE_TTL" envDefault:"15s"`
+	// concurrent /verifiers and /verified-clients requests share one registry walk, see sharedStateRead
+	SingleFlightStateReads    bool            `env:"SINGLE_FLIGHT_STATE_READS" envDefault:"true"`
+	// how long those results are reused, callers can demand fresher with ?maxAge or Cache-Control: max-age. 0 is off
+	StateCacheTTL             time.Duration   `env:"STATE_CACHE_TTL" envDefault:"0"`
+	// most changed clients /admin/verified-clients/diff returns, 0 is unlimited
+	MaxDataCapDiffEntries     int             `env:"MAX_DATACAP_DIFF_ENTRIES" envDefault:"10000"`
+	// actor version whose verified registry state schema is used to decode it, 0 matches the deployed actor
+	VerifregStateVersion      int             `env:"VERIFREG_STATE_VERSION" envDefault:"0"`
+	// hash user addresses in logs, the salt keeps the hashes from being matched against known addresses
+	RedactAddressesInLogs     bool            `env:"REDACT_ADDRESSES_IN_LOGS" envDefault:"false"`
+	LogRedactionSalt          string          `env:"LOG_REDACTION_SALT"`
+	// OTLP/HTTP collector base URL, e.g. http://otel-collector:4318. Tracing is off when unset.
+	OtelEndpoint              string          `env:"OTEL_ENDPOINT"`
+	LockScope                 LockScope       `env:"LOCK_SCOPE" envDefault:"per-operation"`
+	// route=policy overrides of defaultAuthPolicies, see initAuthPolicies
+	AuthPolicies              string          `env:"AUTH_POLICIES"`
+	// locks older than LOCK_TIMEOUT are released once their message has resolved, and unconditionally
+	// after LOCK_HARD_TIMEOUT. 0 turns either off. Keep LOCK_TIMEOUT above the hourly reconcile interval
+	LockTimeout               time.Duration   `env:"LOCK_TIMEOUT" envDefault:"0"`
+	LockHardTimeout           time.Duration   `env:"LOCK_HARD_TIMEOUT" envDefault:"0"`
+	// for providers without an account creation date, see AccountData.CreatedAt
+	UnknownAccountAge         AgeFallback     `env:"UNKNOWN_ACCOUNT_AGE" envDefault:"treat-as-new"`
+	// providers whose accounts get a shorter minimum age (provider:days) or none at all (provider), e.g. a
+	// corporate SSO. This trusts the provider to vet who gets an account, the age check no longer does it for them
+	AgeExemptProviders        ProviderAgeOverrides `env:"AGE_EXEMPT_PROVIDERS"`
+	// GasEstimationFallbackAddr is used as the sender when estimating gas for a value send from a sender that has never sent a message
+	GasEstimationFallbackAddr address.Address `env:"GAS_ESTIMATION_FALLBACK_ADDR"`
 	// verifier specific env vars
 	VerifierPrivateKey        string          `env:"VERIFIER_PK"`
 	VerifierMinAccountAgeDays uint            `env:"VERIFIER_MIN_ACCOUNT_AGE_DAYS" envDefault:"180"`
 	VerifierRateLimit         time.Duration   `env:"VERIFIER_RATE_LIMIT" envDefault:"730h"`
 	MaxAllowanceBytes         big.Int         `env:"MAX_ALLOWANCE_BYTES"`
+	// no single AddVerifiedClient may exceed this, whatever owed works out to. 0 only bounds by the verifier's DataCap
+	MaxClientAllowanceBytes   big.Int         `env:"MAX_CLIENT_ALLOWANCE_BYTES" envDefault:"0"`
 	MaxTotalAllocations       uint            `env:"MAX_TOTAL_ALLOCATIONS" envDefault:"0"`
+	// DataCap the verifier keeps back for in-flight messages and manual allocations
+	VerifierReservedBytes     big.Int         `env:"VERIFIER_RESERVED_BYTES" envDefault:"0"`
+	// allocations larger than this wait for an admin to approve them, 0 turns manual review off
+	ManualReviewThresholdBytes big.Int        `env:"MANUAL_REVIEW_THRESHOLD_BYTES" envDefault:"0"`
+	// total DataCap a user can receive within AllowanceWindow, 0 means no limit. Needs transaction records.
+	MaxAllowancePerWindow     big.Int         `env:"MAX_ALLOWANCE_PER_WINDOW" envDefault:"0"`
+	AllowanceWindow           time.Duration   `env:"ALLOWANCE_WINDOW" envDefault:"2160h"`
+	// also run the verify cooldown from the transaction records, see lastAllocation
+	CooldownFromTransactions  bool            `env:"COOLDOWN_FROM_TRANSACTIONS" envDefault:"true"`
+	// how long the cron jobs wait for a message that can't be found before failing it, 0 waits forever
+	MessageNotFoundTimeout    time.Duration   `env:"MESSAGE_NOT_FOUND_TIMEOUT" envDefault:"0"`
+	// how often the cron jobs check pending messages, and how many they look up at once
+	ReconcileInterval         time.Duration   `env:"RECONCILE_INTERVAL" envDefault:"1h"`
+	MessageSearchConcurrency  int             `env:"MESSAGE_SEARCH_CONCURRENCY" envDefault:"8"`
+	// top-ups smaller than the larger of these are refused, the fraction is of MaxAllowanceBytes
+	MinAllocationBytes        big.Int         `env:"MIN_ALLOCATION_BYTES" envDefault:"0"`
+	MinAllocationFraction     float64         `env:"MIN_ALLOCATION_FRACTION" envDefault:"0"`
+	// cron spec for topping up users with AutoTopUp, e.g. "@daily". Off when unset.
+	TopUpSchedule             string          `env:"TOP_UP_SCHEDULE"`
+	TopUpThresholdBytes       big.Int         `env:"TOP_UP_THRESHOLD_BYTES" envDefault:"0"`
+	// cron spec for sampling verified clients' remaining DataCap, and how far back projections look
+	DataCapSnapshotSchedule   string          `env:"DATACAP_SNAPSHOT_SCHEDULE" envDefault:"@every 6h"`
+	DataCapProjectionWindow   time.Duration   `env:"DATACAP_PROJECTION_WINDOW" envDefault:"720h"`
+	// limits on users verifying a different address than before, returning to an earlier one is always allowed. 0 is off
+	VerifierMaxAddresses      int             `env:"VERIFIER_MAX_ADDRESSES" envDefault:"0"`
+	VerifierAddressCooldown   time.Duration   `env:"VERIFIER_ADDRESS_CHANGE_COOLDOWN" envDefault:"0"`
+	// returned with ALREADY_VERIFIED when the client holds exactly MaxAllowanceBytes, more than that is OVER_CAP
+	AlreadyVerifiedMessage    string          `env:"ALREADY_VERIFIED_MESSAGE" envDefault:"This Filecoin address already has the maximum data cap this notary grants."`
+	// returned when the client holds more than MaxAllowanceBytes, e.g. after the cap was lowered or from another notary
+	OverCapMessage            string          `env:"OVER_CAP_MESSAGE" envDefault:"This Filecoin address already has more data cap than this notary grants."`
+	// verify f1/f3 addresses that haven't appeared on chain yet
+	AllowNewActorTargets      bool            `env:"ALLOW_NEW_ACTOR_TARGETS" envDefault:"true"`
+	// refuse to verify notary addresses, otherwise they're allowed with a slack warning
+	RejectVerifierTargets     bool            `env:"REJECT_VERIFIER_TARGETS" envDefault:"true"`
+	// minimum FIL the target address must hold to be verified, as a stake against throwaway addresses. 0 is off
+	MinTargetBalance          types.FIL       `env:"MIN_TARGET_BALANCE" envDefault:"0fil"`
+	// grant whatever the verifier has left when it's less than MaxAllowanceBytes, instead of refusing
+	AllowPartialAllocation    bool            `env:"ALLOW_PARTIAL_ALLOCATION" envDefault:"true"`
+	// comma separated provider.key=minimum pairs, e.g. "github.followers=5,github.public_repos=1"
+	VerifierMinProviderMetadata string        `env:"VERIFIER_MIN_PROVIDER_METADATA"`
+	// comma separated email domains, when set users need a provider verified email in one of them
+	AllowedEmailDomains       string          `env:"ALLOWED_EMAIL_DOMAINS"`
+	// comma separated signal=weight pairs, signals are account_age_days, linked_providers or provider.key metadata
+	TrustScoreWeights         string          `env:"TRUST_SCORE_WEIGHTS"`
+	MinTrustScore             float64         `env:"MIN_TRUST_SCORE" envDefault:"0"`
 	AllocationsCounterResetPword string       `env:"ALLOCATIONS_COUNTER_PWD"`
 	RedisEndpoint             string          `env:"REDIS_ENDPOINT"`
 	RedisPwd                  string          `env:"REDIS_PASSWORD"`
 	// faucet specific env vars
 	FaucetPrivateKey          string          `env:"FAUCET_PK"`
 	FaucetRateLimit           time.Duration   `env:"FAUCET_RATE_LIMIT" envDefault:"24h"`
+	// repeats of a faucet request within this window get the first one's response, see claimFaucetDedup. 0 is off
+	FaucetDedupWindow         time.Duration   `env:"FAUCET_DEDUP_WINDOW" envDefault:"10s"`
+	// a user that has received a grant can never use the faucet again, FAUCET_RATE_LIMIT only applies otherwise
+	FaucetOneTimeOnly         bool            `env:"FAUCET_ONE_TIME_ONLY" envDefault:"true"`
 	FaucetGrantSize           types.FIL       `env:"FAUCET_GRANT_SIZE" envDefault:"10fil"`
+	// "base" or "proportional", see faucetGrantAmount
+	FaucetFirstTimeMode       FaucetGrantMode `env:"FAUCET_FIRST_TIME_MODE" envDefault:"base"`
+	FaucetFILPerGiB           types.FIL       `env:"FAUCET_FIL_PER_GIB" envDefault:"0fil"`
+	// upper bound on any single grant, 0 means no ceiling. Required with FAUCET_FIRST_TIME_MODE=proportional
+	FaucetMaxGrant            types.FIL       `env:"FAUCET_MAX_GRANT" envDefault:"0fil"`
 	FaucetMinAccountAgeDays   uint            `env:"FAUCET_MIN_ACCOUNT_AGE" envDefault:"180"`
+	FaucetMinerTarget         MinerTarget     `env:"FAUCET_MINER_TARGET" envDefault:"actor"`
+	// miners below this raw byte power are refused, 0 funds any miner
+	FaucetMinMinerPower       big.Int         `env:"FAUCET_MIN_MINER_POWER" envDefault:"0"`
+	// grants per recipient address across all users, lifetime and per FAUCET_ADDRESS_WINDOW, 0 is unlimited
+	FaucetAddressesTableName  string          `env:"DYNAMODB_FAUCET_ADDRESSES_TABLE_NAME"`
+	FaucetAddressMaxGrants    int             `env:"FAUCET_ADDRESS_MAX_GRANTS" envDefault:"0"`
+	FaucetAddressWindowGrants int             `env:"FAUCET_ADDRESS_WINDOW_GRANTS" envDefault:"0"`
+	FaucetAddressWindow       time.Duration   `env:"FAUCET_ADDRESS_WINDOW" envDefault:"720h"`
+	// the recent window reported by /admin/faucet/stats next to the all-time totals
+	FaucetStatsWindow         time.Duration   `env:"FAUCET_STATS_WINDOW" envDefault:"720h"`
+	// re-estimate and retry a faucet message once when the mpool rejects it for low gas
+	FaucetMpoolRetry          bool            `env:"FAUCET_MPOOL_RETRY" envDefault:"true"`
+	// run the faucet's independent reads before sending (miner power, recipient, balance) concurrently
+	FaucetConcurrentReads     bool            `env:"FAUCET_CONCURRENT_READS" envDefault:"true"`
 }
 
 var env Env
 
+// allowedEmailDomains returns the lower cased AllowedEmailDomains, or nil when the check is disabled
+func allowedEmailDomains() []string {
+	if len(env.AllowedEmailDomains) == 0 {
+		return nil
+	}
+	var domains []string
+	for _, domain := range strings.Split(env.AllowedEmailDomains, ",") {
+		domains = append(domains, strings.ToLower(strings.TrimSpace(domain)))
+	}
+	return domains
+}
+
+// minAllocationBytes is the smallest allocation worth sending: the larger of MIN_ALLOCATION_BYTES and
+// MIN_ALLOCATION_FRACTION of MaxAllowanceBytes, rounded down to a whole byte
+func minAllocationBytes() big.Int {
+	minimum := env.MinAllocationBytes
+	if env.MinAllocationFraction <= 0 || env.MaxAllowanceBytes.Int == nil {
+		return minimum
+	}
+
+	fraction := new(gobig.Rat).SetFloat64(env.MinAllocationFraction)
+	scaled := new(gobig.Rat).Mul(fraction, new(gobig.Rat).SetInt(env.MaxAllowanceBytes.Int))
+	fromFraction := big.NewFromGo(new(gobig.Int).Quo(scaled.Num(), scaled.Denom()))
+	return big.Max(minimum, fromFraction)
+}
+
 func init() {
-	err := envpkg.ParseWithFuncs(&env, map[reflect.Type]envpkg.ParserFunc{
+	if err := parseEnv(&env); err != nil {
+		panic(err)
+	}
+}
+
+// parseEnv reads the environment into e, it's also how reloadSettings rereads it
+func parseEnv(e *Env) error {
+	return envpkg.ParseWithFuncs(e, map[reflect.Type]envpkg.ParserFunc{
 		reflect.TypeOf(big.Int{}): func(v string) (interface{}, error) {
 			n, err := big.FromString(v)
 			if err != nil {
@@ -73,8 +329,9 @@ func init() {
 		reflect.TypeOf(address.Address{}): func(v string) (interface{}, error) {
 			return address.NewFromString(v)
 		},
+
+		reflect.TypeOf(ProviderAgeOverrides{}): func(v string) (interface{}, error) {
+			return parseProviderAgeOverrides(v)
+		},
 	})
-	if err != nil {
-		panic(err)
-	}
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// the oldest cached chain head the envelope reports, two epochs
+const envelopeChainHeadMaxAge = time.Minute
+
+// envelopeWriter holds a JSON response back so envelopeResponses can wrap it once the handler is done. Anything
+// else, like /metrics or the ndjson streams, is written straight through so streams still stream.
+type envelopeWriter struct {
+	gin.ResponseWriter
+	buf         bytes.Buffer
+	decided     bool
+	passThrough bool
+}
+
+// buffering decides on the first write whether the response is held back, the handler has set its Content-Type by then
+func (w *envelopeWriter) buffering() bool {
+	if !w.decided {
+		w.decided = true
+		w.passThrough = !strings.Contains(w.Header().Get("Content-Type"), "application/json")
+	}
+	return !w.passThrough
+}
+
+func (w *envelopeWriter) Write(p []byte) (int, error) {
+	if !w.buffering() {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+func (w *envelopeWriter) WriteString(s string) (int, error) {
+	if !w.buffering() {
+		return w.ResponseWriter.WriteString(s)
+	}
+	return w.buf.WriteString(s)
+}
+
+// Flush only reaches the client for pass-through responses, flushing a held back one would send its headers early
+func (w *envelopeWriter) Flush() {
+	if w.passThrough {
+		w.ResponseWriter.Flush()
+	}
+}
+
+type envelopeMeta struct {
+	RequestID   string `json:"requestId"`
+	ChainHeight *int64 `json:"chainHeight,omitempty"`
+}
+
+type envelope struct {
+	Data  json.RawMessage        `json:"data"`
+	Error map[string]interface{} `json:"error"`
+	Meta  envelopeMeta           `json:"meta"`
+}
+
+// requestID is the caller's X-Request-ID, then the trace ID, then a fresh one. It's kept on the context
+// so the envelope and the request log agree.
+func requestID(c *gin.Context) string {
+	if id := c.GetString("requestID"); len(id) > 0 {
+		return id
+	}
+	id := c.GetHeader("X-Request-ID")
+	if len(id) == 0 {
+		if sc := trace.SpanFromContext(spanParent(c)).SpanContext(); sc.IsValid() {
+			id = sc.TraceID.String()
+		} else {
+			id = randomHex(8)
+		}
+	}
+	c.Set("requestID", id)
+	return id
+}
+
+// envelopeResponses wraps JSON responses as {data, error, meta} when RESPONSE_ENVELOPE is on. Successful bodies
+// go in data, and an error body's "error" message becomes error.message alongside its other fields.
+// Non-JSON responses, like /metrics and the ndjson streams, are passed through untouched as they're written.
+// meta.chainHeight is the cached chain head, it's left out rather than asking the node when the cache is cold.
+func envelopeResponses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w := &envelopeWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+		if w.passThrough {
+			return
+		}
+
+		body := w.buf.Bytes()
+		if !strings.Contains(w.Header().Get("Content-Type"), "application/json") || !json.Valid(body) {
+			w.ResponseWriter.Write(body)
+			return
+		}
+
+		out := envelope{Data: json.RawMessage("null"), Meta: envelopeMeta{RequestID: requestID(c)}}
+		if w.Status() >= 400 {
+			out.Error = map[string]interface{}{}
+			var fields map[string]interface{}
+			if err := json.Unmarshal(body, &fields); err != nil {
+				out.Error["message"] = json.RawMessage(body)
+			}
+			for key, value := range fields {
+				if key == "error" {
+					key = "message"
+				}
+				out.Error[key] = value
+			}
+		} else if len(body) > 0 {
+			out.Data = body
+		}
+
+		if head, ok := lastChainHead(envelopeChainHeadMaxAge); ok {
+			out.Meta.ChainHeight = &head.Height
+		}
+
+		w.Header().Set("X-Request-ID", out.Meta.RequestID)
+		w.Header().Del("Content-Length")
+		enveloped, _ := json.Marshal(out)
+		w.ResponseWriter.Write(enveloped)
+	}
+}
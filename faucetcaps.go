@@ -0,0 +1,74 @@
+package main
+
+import "time"
+
+// AddressGrants counts faucet grants per recipient address, across every user that asked for it.
+// WindowStart is unix seconds so it can be compared inside a condition expression.
+type AddressGrants struct {
+	Address      string
+	Grants       int
+	WindowStart  int64
+	WindowGrants int
+}
+
+func addressCapsEnabled() bool {
+	return len(env.FaucetAddressesTableName) > 0 &&
+		(env.FaucetAddressMaxGrants > 0 || env.FaucetAddressWindowGrants > 0)
+}
+
+// claimAddressGrant counts a grant against addr, returning ErrAddressCapReached when the address is at its
+// lifetime or rolling cap. Each branch is a single conditional update, so concurrent requests for the same
+// address can't both squeeze under the cap.
+func claimAddressGrant(addr string) error {
+	if !addressCapsEnabled() {
+		return nil
+	}
+	table := dynamoTable(env.FaucetAddressesTableName)
+	now := time.Now()
+	windowCutoff := now.Add(-env.FaucetAddressWindow).Unix()
+
+	lifetimeCond, lifetimeArgs := "attribute_not_exists(Grants)", []interface{}{}
+	if env.FaucetAddressMaxGrants > 0 {
+		lifetimeCond, lifetimeArgs = "(attribute_not_exists(Grants) OR Grants < ?)", []interface{}{env.FaucetAddressMaxGrants}
+	}
+
+	// inside the current window
+	update := table.Update("Address", addr).
+		Add("Grants", 1).
+		Add("WindowGrants", 1).
+		If("WindowStart >= ?", windowCutoff).
+		If(lifetimeCond, lifetimeArgs...)
+	if env.FaucetAddressWindowGrants > 0 {
+		update = update.If("WindowGrants < ?", env.FaucetAddressWindowGrants)
+	}
+	err := update.Run()
+	if err == nil || !isCondCheckFailed(err) {
+		return err
+	}
+
+	// first grant, or the window has lapsed and starts over
+	err = table.Update("Address", addr).
+		Add("Grants", 1).
+		Set("WindowStart", now.Unix()).
+		Set("WindowGrants", 1).
+		If("attribute_not_exists(WindowStart) OR WindowStart < ?", windowCutoff).
+		If(lifetimeCond, lifetimeArgs...).
+		Run()
+	if err != nil && isCondCheckFailed(err) {
+		return ErrAddressCapReached
+	}
+	return err
+}
+
+// releaseAddressGrant hands back a claimed grant when the faucet message never got sent
+func releaseAddressGrant(addr string) error {
+	if !addressCapsEnabled() {
+		return nil
+	}
+	table := dynamoTable(env.FaucetAddressesTableName)
+	return table.Update("Address", addr).
+		Add("Grants", -1).
+		Add("WindowGrants", -1).
+		If("Grants > ? AND WindowGrants > ?", 0, 0).
+		Run()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// A double-clicked faucet request arrives again before the first has finished, or just after. Rather than
+// have it fail on the user lock, a second request for the same user and address within FAUCET_DEDUP_WINDOW
+// gets the first one's response, or is told it's still in flight.
+type faucetDedupEntry struct {
+	at   time.Time
+	resp interface{}
+}
+
+var (
+	faucetDedup   = make(map[string]faucetDedupEntry)
+	faucetDedupMu sync.Mutex
+)
+
+func faucetDedupKey(userID, targetAddr string) string {
+	return userID + "/" + targetAddr
+}
+
+// claimFaucetDedup marks a request as in flight. If an earlier identical one is within the window it returns
+// false along with that request's response, nil if it hasn't finished yet.
+func claimFaucetDedup(key string) (claimed bool, previous interface{}) {
+	if env.FaucetDedupWindow == 0 {
+		return true, nil
+	}
+	faucetDedupMu.Lock()
+	defer faucetDedupMu.Unlock()
+
+	now := time.Now()
+	for k, entry := range faucetDedup {
+		if now.Sub(entry.at) > env.FaucetDedupWindow {
+			delete(faucetDedup, k)
+		}
+	}
+
+	if entry, ok := faucetDedup[key]; ok {
+		return false, entry.resp
+	}
+	faucetDedup[key] = faucetDedupEntry{at: now}
+	return true, nil
+}
+
+// completeFaucetDedup stores the response to replay, the window runs from when it was sent
+func completeFaucetDedup(key string, resp interface{}) {
+	if env.FaucetDedupWindow == 0 {
+		return
+	}
+	faucetDedupMu.Lock()
+	defer faucetDedupMu.Unlock()
+
+	faucetDedup[key] = faucetDedupEntry{at: time.Now(), resp: resp}
+}
+
+// releaseFaucetDedup forgets a request that failed, so a retry isn't mistaken for a double-click
+func releaseFaucetDedup(key string) {
+	faucetDedupMu.Lock()
+	defer faucetDedupMu.Unlock()
+
+	if entry, ok := faucetDedup[key]; ok && entry.resp == nil {
+		delete(faucetDedup, key)
+	}
+}
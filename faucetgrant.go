@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// FaucetGrantMode decides how a first-time grant is sized
+type FaucetGrantMode string
+
+const (
+	// FaucetGrantModeBase sends everyone FaucetGrantSize
+	FaucetGrantModeBase FaucetGrantMode = "base"
+	// FaucetGrantModeProportional scales a miner's first grant with its raw byte power
+	FaucetGrantModeProportional FaucetGrantMode = "proportional"
+)
+
+// FaucetGrantReason says which rule sized a grant, so a miner can tell why it got what it got
+type FaucetGrantReason string
+
+const (
+	// FaucetGrantReasonBase is the flat FaucetGrantSize, proportional grants are off or the recipient isn't a miner
+	FaucetGrantReasonBase FaucetGrantReason = "base"
+	// FaucetGrantReasonReturning is the flat FaucetGrantSize, only first grants are proportional
+	FaucetGrantReasonReturning FaucetGrantReason = "returning"
+	// FaucetGrantReasonProportional scaled with the miner's raw byte power
+	FaucetGrantReasonProportional FaucetGrantReason = "proportional"
+	// FaucetGrantReasonBelowFloor means the miner's power was worth less than FaucetGrantSize, which was sent instead
+	FaucetGrantReasonBelowFloor FaucetGrantReason = "power_below_floor"
+	// FaucetGrantReasonClamped was cut down to FAUCET_MAX_GRANT
+	FaucetGrantReasonClamped FaucetGrantReason = "clamped"
+)
+
+var faucetGrantReasonMessages = map[FaucetGrantReason]string{
+	FaucetGrantReasonBase:         "Standard faucet grant.",
+	FaucetGrantReasonReturning:    "Standard faucet grant, only a miner's first grant scales with its power.",
+	FaucetGrantReasonProportional: "Grant scaled with the miner's raw byte power.",
+	FaucetGrantReasonBelowFloor:   "The miner's raw byte power is too low for a proportional grant, so it got the standard grant.",
+	FaucetGrantReasonClamped:      "Grant capped at this faucet's maximum.",
+}
+
+// Message is a user facing explanation of the reason
+func (reason FaucetGrantReason) Message() string {
+	return faucetGrantReasonMessages[reason]
+}
+
+var gib = big.NewInt(1 << 30)
+
+// faucetGrantAmount works out how much FIL to send. With FAUCET_FIRST_TIME_MODE=proportional a miner's first
+// grant is FAUCET_FIL_PER_GIB for each GiB of raw byte power, never less than FaucetGrantSize.
+// Everything else, including returning users, gets FaucetGrantSize.
+// The result is capped at FAUCET_MAX_GRANT when that's set, which it always is for proportional grants,
+// see settingsFromEnv. clamped reports whether the cap applied.
+func faucetGrantAmount(firstTime, isMiner bool, power big.Int) (grant types.FIL, clamped bool, reason FaucetGrantReason) {
+	grant = settings().FaucetGrantSize
+	reason = FaucetGrantReasonBase
+	if !firstTime {
+		reason = FaucetGrantReasonReturning
+	}
+	if firstTime && isMiner && env.FaucetFirstTimeMode == FaucetGrantModeProportional {
+		proportional := big.Div(big.Mul(big.Int(env.FaucetFILPerGiB), power), gib)
+		if proportional.GreaterThan(big.Int(grant)) {
+			grant = types.FIL(proportional)
+			reason = FaucetGrantReasonProportional
+		} else {
+			reason = FaucetGrantReasonBelowFloor
+		}
+	}
+
+	ceiling := big.Int(settings().FaucetMaxGrant)
+	if !ceiling.IsZero() && big.Int(grant).GreaterThan(ceiling) {
+		return settings().FaucetMaxGrant, true, FaucetGrantReasonClamped
+	}
+	return grant, false, reason
+}
+
+// needsMinerPower reports whether serveFaucet has to look up a miner's power at all
+func needsMinerPower() bool {
+	return !env.FaucetMinMinerPower.IsZero() || env.FaucetFirstTimeMode == FaucetGrantModeProportional
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// runReads runs independent lotus reads, concurrently unless FAUCET_CONCURRENT_READS is off. Reads set their
+// results through closures, the first error cancels the rest and is returned.
+func runReads(ctx context.Context, reads ...func(ctx context.Context) error) error {
+	if !env.FaucetConcurrentReads {
+		for _, read := range reads {
+			if err := read(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	for _, read := range reads {
+		read := read
+		g.Go(func() error {
+			return read(ctx)
+		})
+	}
+	return g.Wait()
+}
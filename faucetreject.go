@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// FaucetRejectReason says which check turned a faucet request down, so the frontend can show more than the message
+type FaucetRejectReason string
+
+const (
+	FaucetRejectNoLinkedAccounts FaucetRejectReason = "no_linked_accounts"
+	FaucetRejectUserBlocked      FaucetRejectReason = "user_blocked"
+	FaucetRejectInProgress       FaucetRejectReason = "in_progress"
+	// FaucetRejectAlreadyClaimed is a second request with FAUCET_ONE_TIME_ONLY, there's never a next time
+	FaucetRejectAlreadyClaimed FaucetRejectReason = "already_claimed"
+	// FaucetRejectRateLimited comes with nextEligibleAt
+	FaucetRejectRateLimited FaucetRejectReason = "rate_limited"
+	// FaucetRejectTooNew comes with minAccountAgeDays
+	FaucetRejectTooNew         FaucetRejectReason = "account_too_new"
+	FaucetRejectInvalidAddress FaucetRejectReason = "invalid_address"
+	FaucetRejectAddressBlocked FaucetRejectReason = "address_blocked"
+	// FaucetRejectMinerPower comes with the miner's power and the minPower it needed
+	FaucetRejectMinerPower FaucetRejectReason = "miner_power_too_low"
+	// FaucetRejectAddressCap means the recipient address, not the user, has had its grants
+	FaucetRejectAddressCap FaucetRejectReason = "address_cap_reached"
+)
+
+// rejectFaucet responds to a faucet request a check turned down. Every rejection carries the error message
+// and its reason, fields adds whatever the frontend needs to render that reason.
+func rejectFaucet(c *gin.Context, code int, reason FaucetRejectReason, err error, fields gin.H) {
+	body := gin.H{"error": err.Error(), "reason": reason}
+	for key, value := range fields {
+		body[key] = value
+	}
+	c.JSON(code, body)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/gin-gonic/gin"
+)
+
+type faucetTotals struct {
+	Sent             types.FIL `json:"sent"`
+	Grants           int       `json:"grants"`
+	UniqueRecipients int       `json:"uniqueRecipients"`
+}
+
+// getFaucetTotals sums the faucet transactions that weren't failed or replaced, all time and since windowStart
+func getFaucetTotals(ctx context.Context, windowStart time.Time) (faucetTotals, faucetTotals, error) {
+	table := dynamoTable(env.DynamodbTransactionsTableName)
+	iter := table.Scan().
+		Filter("$ = ? AND $ <> ? AND $ <> ?", "Type", TransactionType_Faucet, "Status", TransactionStatus_Failed, "Status", TransactionStatus_Replaced).
+		Iter()
+
+	allTime, window := faucetTotals{Sent: types.FIL(big.Zero())}, faucetTotals{Sent: types.FIL(big.Zero())}
+	allTimeRecipients, windowRecipients := map[string]bool{}, map[string]bool{}
+
+	var tx Transaction
+	for iter.NextWithContext(ctx, &tx) {
+		amount, err := types.ParseFIL(tx.Amount)
+		if err != nil {
+			return faucetTotals{}, faucetTotals{}, err
+		}
+		recipient := tx.Address
+		if len(tx.ResolvedAddress) > 0 {
+			recipient = tx.ResolvedAddress
+		}
+
+		allTime.Sent = types.FIL(big.Add(big.Int(allTime.Sent), big.Int(amount)))
+		allTime.Grants++
+		allTimeRecipients[recipient] = true
+		if tx.CreatedAt.After(windowStart) {
+			window.Sent = types.FIL(big.Add(big.Int(window.Sent), big.Int(amount)))
+			window.Grants++
+			windowRecipients[recipient] = true
+		}
+	}
+	allTime.UniqueRecipients = len(allTimeRecipients)
+	window.UniqueRecipients = len(windowRecipients)
+	return allTime, window, iter.Err()
+}
+
+// serveFaucetStats reports faucet spend for budgeting. It's aggregate figures only, nothing about users, but it
+// scans every faucet transaction so it's served under /admin.
+func serveFaucetStats(c *gin.Context) {
+	if !transactionsEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "transaction records are not enabled"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(tracedBackground(c), 30*time.Second)
+	defer cancel()
+
+	allTime, window, err := getFaucetTotals(ctx, time.Now().Add(-env.FaucetStatsWindow))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	balance, err := lotusWalletBalance(ctx, FaucetAddr)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"allTime":        allTime,
+		"window":         window,
+		"windowDuration": env.FaucetStatsWindow.String(),
+		"balance":        types.FIL(balance),
+		"faucet":         FaucetAddr.String(),
+	})
+}
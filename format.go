@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	gobig "math/big"
+	"strings"
+
+	"github.com/filecoin-project/go-state-types/big"
+)
+
+var byteUnits = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+
+// formatBytesHuman formats a byte count with binary units, e.g. "12.5 TiB"
+func formatBytesHuman(n big.Int) string {
+	if n.Int == nil {
+		return "0 B"
+	}
+
+	f := new(gobig.Float).SetInt(n.Int)
+	unit := gobig.NewFloat(1024)
+	i := 0
+	for i < len(byteUnits)-1 && f.Cmp(unit) >= 0 {
+		f.Quo(f, unit)
+		i++
+	}
+
+	formatted := strings.TrimSuffix(f.Text('f', 1), ".0")
+	return fmt.Sprintf("%v %v", formatted, byteUnits[i])
+}
+
+// formatBytesHex formats a byte count as a 0x prefixed hex string
+func formatBytesHex(n big.Int) string {
+	if n.Int == nil {
+		return "0x0"
+	}
+	return "0x" + n.Int.Text(16)
+}
@@ -11,8 +11,10 @@ require (
 	github.com/filecoin-project/go-state-types v0.1.1-0.20210506134452-99b279731c48
 	github.com/filecoin-project/lotus v1.10.0
 	github.com/filecoin-project/specs-actors/v4 v4.0.0
+	github.com/filecoin-project/specs-actors/v5 v5.0.1
 	github.com/gin-contrib/cors v1.3.1
 	github.com/gin-gonic/gin v1.6.3
+	github.com/go-playground/validator/v10 v10.2.0
 	github.com/go-redis/redis/v8 v8.6.0
 	github.com/google/uuid v1.2.0
 	github.com/guregu/dynamo v1.10.2
@@ -21,6 +23,11 @@ require (
 	github.com/ipfs/go-ipld-cbor v0.0.5
 	github.com/pkg/errors v0.9.1
 	github.com/whyrusleeping/cbor-gen v0.0.0-20210303213153-67a261a1d291
+	go.opentelemetry.io/otel v0.17.0
+	go.opentelemetry.io/otel/exporters/otlp v0.17.0
+	go.opentelemetry.io/otel/sdk v0.17.0
+	go.opentelemetry.io/otel/trace v0.17.0
+	golang.org/x/sync v0.0.0-20201207232520-09787c993a3a
 	gopkg.in/robfig/cron.v2 v2.0.0-20150107220207-be2e0b0deed5
 )
 
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serveHealth reports the state of each subsystem, unlike /healthz which only says the process is up
+func serveHealth(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(tracedBackground(c), 30*time.Second)
+	defer cancel()
+
+	resp := gin.H{"status": "ok", "ready": isWarmedUp(), "warmUp": warmUpStatus(), "monitors": monitors.Statuses()}
+
+	if env.Mode != FaucetMode {
+		dataCap, err := lotusCheckVerifierRemainingBytes(ctx, VerifierAddr.String())
+		if err != nil {
+			resp["verifier"] = gin.H{"error": err.Error()}
+		} else {
+			resp["verifier"] = gin.H{
+				"remainingBytes": dataCap.String(),
+				"reservedBytes":  env.VerifierReservedBytes.String(),
+				"availableBytes": verifierAvailableBytes(dataCap).String(),
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
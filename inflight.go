@@ -0,0 +1,42 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/filecoin-project/go-address"
+)
+
+// inflightAddresses tracks target addresses that have a verify message submitted but not yet resolved,
+// since user locks alone don't stop two different users from targeting the same address at once
+var (
+	inflightAddresses   = make(map[address.Address]bool)
+	inflightAddressesMu sync.Mutex
+)
+
+// claimInflightAddress returns false if the address already has a verify in flight
+func claimInflightAddress(addr address.Address) bool {
+	inflightAddressesMu.Lock()
+	defer inflightAddressesMu.Unlock()
+
+	if inflightAddresses[addr] {
+		return false
+	}
+	inflightAddresses[addr] = true
+	return true
+}
+
+func releaseInflightAddress(addr address.Address) {
+	inflightAddressesMu.Lock()
+	defer inflightAddressesMu.Unlock()
+
+	delete(inflightAddresses, addr)
+}
+
+// releaseInflightAddressString is used by the cron job, which only has the address as stored on the user record
+func releaseInflightAddressString(addrStr string) {
+	addr, err := address.NewFromString(addrStr)
+	if err != nil {
+		return
+	}
+	releaseInflightAddress(addr)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serveIntrospectJWT lets internal services check a user JWT without holding our signing secret.
+// Loosely follows OAuth token introspection (RFC 7662): anything that isn't a valid token for a
+// current user is just reported as inactive, with no reason given.
+func serveIntrospectJWT(c *gin.Context) {
+	type Request struct {
+		Token string `json:"token" form:"token" binding:"required"`
+	}
+	type Response struct {
+		Active bool   `json:"active"`
+		Sub    string `json:"sub,omitempty"`
+		Scope  string `json:"scope,omitempty"`
+		Exp    int64  `json:"exp,omitempty"`
+	}
+
+	var body Request
+	if err := c.ShouldBind(&body); err != nil {
+		c.JSON(http.StatusBadRequest, bindingErrorBody(err))
+		return
+	}
+
+	userID, claims, err := parseUserJWT(body.Token)
+	if err != nil {
+		c.JSON(http.StatusOK, Response{Active: false})
+		return
+	}
+
+	// tokens for users that no longer exist, e.g. after a network reset, are stale
+	if _, err := getUserByID(userID); err != nil {
+		c.JSON(http.StatusOK, Response{Active: false})
+		return
+	}
+
+	resp := Response{Active: true, Sub: userID}
+	if scope, ok := claims["scope"].(string); ok {
+		resp.Scope = scope
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		resp.Exp = int64(exp)
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
-	"context"
 	"time"
 	"github.com/ipfs/go-cid"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func sendSlackMessage(message string) {
@@ -11,36 +11,96 @@ func sendSlackMessage(message string) {
 	return
 }
 
+// messageNotFoundExpired reports whether a message StateSearchMsg can't find was sent more than
+// MESSAGE_NOT_FOUND_TIMEOUT ago, so it's given up on as failed instead of keeping the user locked forever
+func messageNotFoundExpired(cid string) bool {
+	if env.MessageNotFoundTimeout == 0 || !transactionsEnabled() {
+		return false
+	}
+	tx, err := getTransaction(cid)
+	if err != nil {
+		return false
+	}
+	return time.Since(tx.CreatedAt) > env.MessageNotFoundTimeout
+}
+
+// reconcileSchedule is the cron spec for the reconcile jobs
+func reconcileSchedule() string {
+	return "@every " + env.ReconcileInterval.String()
+}
+
 func reconcileVerifierMessages() {
+	ctx, span := startSpan(backgroundCtx, "reconcileVerifierMessages", trace.SpanKindInternal)
+	defer span.End()
+
 	users, err := getLockedUsers(UserLock_Verifier)
 	if err != nil {
 		sendSlackMessage(err.Error()+"error getting locked users")
 		return
 	}
 
+	var pending []User
+	var cids []cid.Cid
 	for _, user := range users {
 		cid, err := cid.Decode(user.MostRecentDataCapCid)
 		if err != nil {
-			sendSlackMessage(err.Error())
-			return
+			// one bad record mustn't hold up everyone else's reconciliation, releaseStaleLocks gets to it
+			sendSlackMessage(err.Error() + " decoding verify message cid for user " + user.ID)
+			continue
+		}
+		// a replaced message will never land, unlock so the user can try again
+		if isTransactionReplaced(user.MostRecentDataCapCid) {
+			releaseInflightAddressString(user.MostRecentVerifiedAddress)
+			clearUserLock(&user, UserLock_Verifier)
+			if err := saveUser(user); err != nil {
+				sendSlackMessage(err.Error())
+			}
+			continue
 		}
-		mLookup, err := lotusSearchMessageResult(context.TODO(), cid)
+		pending = append(pending, user)
+		cids = append(cids, cid)
+	}
+
+	lookups := lotusSearchMessages(ctx, cids)
+	for i, user := range pending {
+		mLookup, err := lookups[i].lookup, lookups[i].err
 		if err != nil {
 			sendSlackMessage(err.Error())
 			return
 		}
 
-		finished := mLookup != nil
+		// not on chain (yet), either still in the mpool or dropped from it
+		if mLookup == nil {
+			if messageNotFoundExpired(user.MostRecentDataCapCid) {
+				sendSlackMessage("MESSAGE NOT FOUND, UNLOCKING: " + user.MostRecentDataCapCid)
+				releaseInflightAddressString(user.MostRecentVerifiedAddress)
+				if err := resolveTransaction(user.MostRecentDataCapCid, false); err != nil {
+					sendSlackMessage(err.Error())
+				}
+				clearUserLock(&user, UserLock_Verifier)
+				if err := saveUser(user); err != nil {
+					sendSlackMessage(err.Error())
+				}
+			}
+			continue
+		}
+
 		confirmed := mLookup.Receipt.ExitCode.IsSuccess()
-		if finished && confirmed {
+		releaseInflightAddressString(user.MostRecentVerifiedAddress)
+		if err := resolveTransaction(user.MostRecentDataCapCid, confirmed); err != nil {
+			sendSlackMessage(err.Error())
+		}
+		if confirmed {
 			user.MostRecentAllocation = time.Now()
-			user.Locked_Verifier = false
+			clearUserLock(&user, UserLock_Verifier)
 			err = saveUser(user)
 			if err != nil {
 				sendSlackMessage(err.Error())
 				return
 			}
-		} else if finished {
+			notifyAllocation(user)
+			archiveReceipt(cids[i], mLookup)
+		} else {
 			sendSlackMessage("TRANSACTION FAILED: "+mLookup.Receipt.ExitCode.Error())
 			return
 		}
@@ -48,6 +108,9 @@ func reconcileVerifierMessages() {
 }
 
 func reconcileFaucetMessages() {
+	ctx, span := startSpan(backgroundCtx, "reconcileFaucetMessages", trace.SpanKindInternal)
+	defer span.End()
+
 	sendSlackMessage("RUNNING FAUCET JOB")
 	users, err := getLockedUsers(UserLock_Faucet)
 	if err != nil {
@@ -55,29 +118,61 @@ func reconcileFaucetMessages() {
 		return
 	}
 
+	var pending []User
+	var cids []cid.Cid
 	for _, user := range users {
 		cid, err := cid.Decode(user.MostRecentFaucetGrantCid)
 		if err != nil {
-			sendSlackMessage(err.Error())
-			return
+			// see reconcileVerifierMessages, a first-time user locked without a grant has no cid at all
+			sendSlackMessage(err.Error() + " decoding faucet message cid for user " + user.ID)
+			continue
+		}
+		if isTransactionReplaced(user.MostRecentFaucetGrantCid) {
+			clearUserLock(&user, UserLock_Faucet)
+			if err := saveUser(user); err != nil {
+				sendSlackMessage(err.Error())
+			}
+			continue
 		}
-		mLookup, err := lotusSearchMessageResult(context.TODO(), cid)
+		pending = append(pending, user)
+		cids = append(cids, cid)
+	}
+
+	lookups := lotusSearchMessages(ctx, cids)
+	for i, user := range pending {
+		mLookup, err := lookups[i].lookup, lookups[i].err
 		if err != nil {
 			sendSlackMessage(err.Error())
 			return
 		}
 
-		finished := mLookup != nil
+		if mLookup == nil {
+			if messageNotFoundExpired(user.MostRecentFaucetGrantCid) {
+				sendSlackMessage("MESSAGE NOT FOUND, UNLOCKING: " + user.MostRecentFaucetGrantCid)
+				if err := resolveTransaction(user.MostRecentFaucetGrantCid, false); err != nil {
+					sendSlackMessage(err.Error())
+				}
+				clearUserLock(&user, UserLock_Faucet)
+				if err := saveUser(user); err != nil {
+					sendSlackMessage(err.Error())
+				}
+			}
+			continue
+		}
+
 		confirmed := mLookup.Receipt.ExitCode.IsSuccess()
-		if finished && confirmed {
+		if err := resolveTransaction(user.MostRecentFaucetGrantCid, confirmed); err != nil {
+			sendSlackMessage(err.Error())
+		}
+		if confirmed {
 			user.ReceivedFaucetGrant = true
-			user.Locked_Faucet = false
+			clearUserLock(&user, UserLock_Faucet)
 			err = saveUser(user)
 			if err != nil {
 				sendSlackMessage(err.Error())
 				return
 			}
-		} else if finished {
+		} else {
 			sendSlackMessage("TRANSACTION FAILED: "+mLookup.Receipt.ExitCode.Error())
 			return
 		}
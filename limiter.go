@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// providerSemaphores bound how many token exchanges run against each provider at once,
+// so a login spike doesn't trip the provider's own rate limits
+var (
+	providerSemaphores   = make(map[string]chan struct{})
+	providerSemaphoresMu sync.Mutex
+)
+
+// providerConcurrencyLimit reads the provider's limit from OAUTH_CONCURRENCY_LIMITS, falling back to OAUTH_CONCURRENCY
+func providerConcurrencyLimit(providerName string) int {
+	for _, e := range strings.Split(env.OAuthConcurrencyLimits, ",") {
+		parts := strings.SplitN(strings.TrimSpace(e), "=", 2)
+		if len(parts) != 2 || parts[0] != providerName {
+			continue
+		}
+		if n, err := strconv.Atoi(parts[1]); err == nil && n > 0 {
+			return n
+		}
+	}
+	return env.OAuthConcurrency
+}
+
+func providerSemaphore(providerName string) chan struct{} {
+	providerSemaphoresMu.Lock()
+	defer providerSemaphoresMu.Unlock()
+
+	sem, exists := providerSemaphores[providerName]
+	if !exists {
+		sem = make(chan struct{}, providerConcurrencyLimit(providerName))
+		providerSemaphores[providerName] = sem
+	}
+	return sem
+}
+
+// acquireProviderSlot waits up to OAUTH_QUEUE_TIMEOUT for a free slot. The returned func releases it.
+func acquireProviderSlot(providerName string) (func(), bool) {
+	sem := providerSemaphore(providerName)
+
+	timer := time.NewTimer(env.OAuthQueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, true
+	case <-timer.C:
+		return nil, false
+	}
+}
+
+// verifySubmitSlots bounds how many verify messages are submitted to the node at once. Requests past the
+// limit wait in a queue of up to VERIFY_QUEUE_SIZE instead of failing while the node is congested.
+// With VERIFY_SUBMIT_CONCURRENCY=1 submissions are serialized, so nonces are never assigned concurrently.
+var verifySubmitSlots chan struct{}
+
+func initVerifySubmitQueue() {
+	if env.VerifySubmitConcurrency > 0 {
+		verifySubmitSlots = make(chan struct{}, env.VerifySubmitConcurrency)
+	}
+}
+
+// acquireVerifySubmitSlot waits up to VERIFY_QUEUE_TIMEOUT for a free slot. queueFull is set when the
+// request was turned away without queueing, and is the only case a Retry-After is worth sending.
+func acquireVerifySubmitSlot(ctx context.Context) (release func(), queueFull bool, err error) {
+	if verifySubmitSlots == nil {
+		return func() {}, false, nil
+	}
+
+	select {
+	case verifySubmitSlots <- struct{}{}:
+		return func() { <-verifySubmitSlots }, false, nil
+	default:
+	}
+
+	if verifyQueueDepth.Value() >= int64(env.VerifyQueueSize) {
+		verifyQueueRejected.Add(1)
+		return nil, true, ErrVerifyQueueFull
+	}
+	verifyQueueDepth.Add(1)
+	defer verifyQueueDepth.Add(-1)
+
+	timer := time.NewTimer(env.VerifyQueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case verifySubmitSlots <- struct{}{}:
+		return func() { <-verifySubmitSlots }, false, nil
+	case <-timer.C:
+		return nil, false, ErrNodeBusy
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
@@ -3,8 +3,11 @@ package main
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"log"
+	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -18,6 +21,8 @@ import (
 	apibstore "github.com/filecoin-project/lotus/blockstore"
 	"github.com/filecoin-project/lotus/chain/actors"
 	"github.com/filecoin-project/lotus/chain/actors/adt"
+	lotusbuiltin "github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
 	verifregany "github.com/filecoin-project/lotus/chain/actors/builtin/verifreg"
 	"github.com/filecoin-project/lotus/chain/types"
 	cliutil "github.com/filecoin-project/lotus/cli/util"
@@ -27,26 +32,69 @@ import (
 	"github.com/ipfs/go-hamt-ipld"
 	cbor "github.com/ipfs/go-ipld-cbor"
 	cbg "github.com/whyrusleeping/cbor-gen"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func lotusVerifyAccount(ctx context.Context, targetAddr string, allowance types.BigInt) (cid.Cid, error) {
-	target, err := address.NewFromString(targetAddr)
-	if err != nil {
+	if err := lotusCheckAllowanceSane(ctx, allowance); err != nil {
 		return cid.Cid{}, err
 	}
 
-	params, err := actors.SerializeParams(&verifreg.AddVerifiedClientParams{Address: target, Allowance: allowance})
+	lapi, closer, err := lotusGetFullNodeAPI(ctx)
 	if err != nil {
 		return cid.Cid{}, err
 	}
+	defer closer()
 
-	lapi, closer, err := lotusGetFullNodeAPI(ctx)
+	msgWithGas, err := lotusVerifyMessage(ctx, lapi, targetAddr, allowance)
 	if err != nil {
 		return cid.Cid{}, err
 	}
-	defer closer()
 
-	nonce, err := lapi.MpoolGetNonce(ctx, VerifierAddr)
+	mCid, err := lotusSignAndPush(ctx, lapi, msgWithGas)
+	if err != nil {
+		return cid.Cid{}, errors.Wrap(err, "submitting message")
+	}
+	return mCid, nil
+}
+
+// lotusCheckAllowanceSane refuses allowances no correct request could produce, more than the verifier
+// holds in total or more than MAX_CLIENT_ALLOWANCE_BYTES, so a bad owed calculation never reaches the chain
+func lotusCheckAllowanceSane(ctx context.Context, allowance types.BigInt) error {
+	if !env.MaxClientAllowanceBytes.IsZero() && allowance.GreaterThan(env.MaxClientAllowanceBytes) {
+		return errors.Wrapf(ErrAllowanceImplausible, "allowance %v is over MAX_CLIENT_ALLOWANCE_BYTES %v", allowance, env.MaxClientAllowanceBytes)
+	}
+
+	remaining, err := lotusCheckVerifierRemainingBytes(ctx, VerifierAddr.String())
+	if err != nil {
+		return err
+	}
+	if allowance.GreaterThan(remaining) {
+		return errors.Wrapf(ErrAllowanceImplausible, "allowance %v is over the verifier's remaining %v", allowance, remaining)
+	}
+	return nil
+}
+
+// lotusVerifyMessage builds the AddVerifiedClient message for the next verifier nonce, with gas estimated
+func lotusVerifyMessage(ctx context.Context, lapi v0api.FullNode, targetAddr string, allowance types.BigInt) (*types.Message, error) {
+	target, err := address.NewFromString(targetAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := actors.SerializeParams(&verifreg.AddVerifiedClientParams{Address: target, Allowance: allowance})
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce uint64
+	err = traceRPC(ctx, "MpoolGetNonce", func(ctx context.Context) (err error) {
+		nonce, err = lapi.MpoolGetNonce(ctx, VerifierAddr)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	msg := &types.Message{
 		To:     builtin.VerifiedRegistryActorAddr,
@@ -55,26 +103,22 @@ func lotusVerifyAccount(ctx context.Context, targetAddr string, allowance types.
 		Params: params,
 		Nonce:  nonce,
 	}
+	return lotusEstimateMessageGas(ctx, lapi, msg)
+}
 
-	sendSpec := &api.MessageSendSpec{
-		MaxFee: types.BigInt(env.MaxFee),
-	}
-
-	msgWithGas, err := lapi.GasEstimateMessageGas(ctx, msg, sendSpec, types.EmptyTSK)
-	if err != nil {
-		return cid.Cid{}, err
-	}
-
-	sig, err := walletSignMessage(ctx, VerifierAddr, msgWithGas.Cid().Bytes(), api.MsgMeta{Type: api.MTUnknown})
+// lotusEstimateVerifyFee is the most a verify message for allowance could cost, GasFeeCap * GasLimit
+func lotusEstimateVerifyFee(ctx context.Context, targetAddr string, allowance types.BigInt) (big.Int, error) {
+	lapi, closer, err := lotusGetFullNodeAPI(ctx)
 	if err != nil {
-		return cid.Cid{}, err
+		return big.Zero(), err
 	}
+	defer closer()
 
-	mCid, err := lapi.MpoolPush(ctx, &types.SignedMessage{Signature: *sig, Message: *msgWithGas})
+	msg, err := lotusVerifyMessage(ctx, lapi, targetAddr, allowance)
 	if err != nil {
-		return cid.Cid{}, err
+		return big.Zero(), err
 	}
-	return mCid, nil
+	return big.Mul(msg.GasFeeCap, big.NewInt(msg.GasLimit)), nil
 }
 
 type addrAndDataCap struct {
@@ -83,6 +127,10 @@ type addrAndDataCap struct {
 }
 
 func lotusListVerifiers(ctx context.Context) ([]addrAndDataCap, error) {
+	return sharedStateRead(ctx, "verifiers", lotusLoadVerifiers)
+}
+
+func lotusLoadVerifiers(ctx context.Context) ([]addrAndDataCap, error) {
 	api, closer, err := lotusGetFullNodeAPI(ctx)
 	if err != nil {
 		return nil, err
@@ -97,8 +145,8 @@ func lotusListVerifiers(ctx context.Context) ([]addrAndDataCap, error) {
 	apibs := apibstore.NewAPIBlockstore(api)
 	cst := cbor.NewCborStore(apibs)
 
-	var st verifreg.State
-	if err := cst.Get(ctx, act.Head, &st); err != nil {
+	st, err := decodeVerifregState(ctx, cst, act)
+	if err != nil {
 		return nil, err
 	}
 
@@ -126,32 +174,49 @@ func lotusListVerifiers(ctx context.Context) ([]addrAndDataCap, error) {
 }
 
 func lotusListVerifiedClients(ctx context.Context) ([]addrAndDataCap, error) {
+	return sharedStateRead(ctx, "verified-clients", func(ctx context.Context) ([]addrAndDataCap, error) {
+		var resp []addrAndDataCap
+		err := lotusWalkVerifiedClients(ctx, func(client addrAndDataCap) error {
+			resp = append(resp, client)
+			return nil
+		})
+		return resp, err
+	})
+}
+
+// lotusWalkVerifiedClients calls fn for each verified client as it's read from the HAMT,
+// stopping at the first error fn returns
+func lotusWalkVerifiedClients(ctx context.Context, fn func(addrAndDataCap) error) error {
+	return lotusWalkVerifiedClientsAt(ctx, types.EmptyTSK, fn)
+}
+
+// lotusWalkVerifiedClientsAt is lotusWalkVerifiedClients at the given tipset, which the node must still have state for
+func lotusWalkVerifiedClientsAt(ctx context.Context, tsk types.TipSetKey, fn func(addrAndDataCap) error) error {
 	api, closer, err := lotusGetFullNodeAPI(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer closer()
 
-	act, err := api.StateGetActor(ctx, builtin.VerifiedRegistryActorAddr, types.EmptyTSK)
+	act, err := api.StateGetActor(ctx, builtin.VerifiedRegistryActorAddr, tsk)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	apibs := apibstore.NewAPIBlockstore(api)
 	cst := cbor.NewCborStore(apibs)
 
-	var st verifreg.State
-	if err := cst.Get(ctx, act.Head, &st); err != nil {
-		return nil, err
+	st, err := decodeVerifregState(ctx, cst, act)
+	if err != nil {
+		return err
 	}
 
 	vh, err := hamt.LoadNode(ctx, cst, st.VerifiedClients, hamt.UseTreeBitWidth(5))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	var resp []addrAndDataCap
-	err = vh.ForEach(ctx, func(k string, val interface{}) error {
+	return vh.ForEach(ctx, func(k string, val interface{}) error {
 		addr, err := address.NewFromBytes([]byte(k))
 		if err != nil {
 			return err
@@ -161,11 +226,8 @@ func lotusListVerifiedClients(ctx context.Context) ([]addrAndDataCap, error) {
 		if err := dcap.UnmarshalCBOR(bytes.NewReader(val.(*cbg.Deferred).Raw)); err != nil {
 			return err
 		}
-		resp = append(resp, addrAndDataCap{addr, dcap})
-		return nil
-
+		return fn(addrAndDataCap{addr, dcap})
 	})
-	return resp, err
 }
 
 func ignoreNotFound(err error) error {
@@ -187,8 +249,12 @@ func lotusCheckAccountRemainingBytes(ctx context.Context, targetAddr string) (bi
 	}
 	defer closer()
 
-	dcap, err := api.StateVerifiedClientStatus(ctx, caddr, types.EmptyTSK)
-	err = ignoreNotFound(err)
+	var dcap *big.Int
+	err = traceRPC(ctx, "StateVerifiedClientStatus", func(ctx context.Context) (err error) {
+		dcap, err = api.StateVerifiedClientStatus(ctx, caddr, types.EmptyTSK)
+		// an address that isn't on chain yet fails to resolve, it has no DataCap either way
+		return ignoreNotFound(err)
+	})
 
 	if err != nil {
 		return big.Int{}, err
@@ -205,59 +271,248 @@ func lotusCheckVerifierRemainingBytes(ctx context.Context, targetAddr string) (b
 		return big.Int{}, err
 	}
 
-	api, closer, err := lotusGetFullNodeAPI(ctx)
+	found, dcap, err := lotusVerifierDataCap(ctx, vaddr)
 	if err != nil {
 		return big.Int{}, err
 	}
+	if !found {
+		return big.Int{}, errors.New("not found")
+	}
+
+	return dcap, nil
+}
+
+// lotusIsVerifier reports whether addr is a notary in the verified registry
+func lotusIsVerifier(ctx context.Context, addr address.Address) (bool, error) {
+	found, _, err := lotusVerifierDataCap(ctx, addr)
+	return found, err
+}
+
+// lotusVerifierDataCap looks addr up in the verified registry's verifiers. Addresses not yet on chain can't be verifiers.
+func lotusVerifierDataCap(ctx context.Context, vaddr address.Address) (bool, big.Int, error) {
+	api, closer, err := lotusGetFullNodeAPI(ctx)
+	if err != nil {
+		return false, big.Int{}, err
+	}
 	defer closer()
 
 	head, err := api.ChainHead(ctx)
 	if err != nil {
-		return big.Int{}, err
+		return false, big.Int{}, err
 	}
 
 	act, err := api.StateGetActor(ctx, builtin.VerifiedRegistryActorAddr, head.Key())
 	if err != nil {
-		return big.Int{}, err
+		return false, big.Int{}, err
 	}
 
 	vid, err := api.StateLookupID(ctx, vaddr, head.Key())
-	if err != nil {
-		return big.Int{}, err
+	if ignoreNotFound(err) != nil {
+		return false, big.Int{}, err
+	} else if err != nil {
+		return false, big.Int{}, nil
 	}
 
 	apibs := apibstore.NewAPIBlockstore(api)
 	store := adt.WrapStore(ctx, cbor.NewCborStore(apibs))
 
 	st, err := verifregany.Load(store, act)
+	if err != nil {
+		return false, big.Int{}, err
+	}
+
+	return st.VerifierDataCap(vid)
+}
+
+// verifierAvailableBytes is the verifier's remaining DataCap less the configured reserve, floored at zero
+func verifierAvailableBytes(remaining big.Int) big.Int {
+	available := big.Sub(remaining, env.VerifierReservedBytes)
+	if available.LessThan(big.Zero()) {
+		return big.Zero()
+	}
+	return available
+}
+
+func lotusWalletBalance(ctx context.Context, addr address.Address) (big.Int, error) {
+	lapi, closer, err := lotusGetFullNodeAPI(ctx)
 	if err != nil {
 		return big.Int{}, err
 	}
+	defer closer()
+
+	var balance types.BigInt
+	err = traceRPC(ctx, "WalletBalance", func(ctx context.Context) (err error) {
+		balance, err = lapi.WalletBalance(ctx, addr)
+		return err
+	})
+	return balance, err
+}
+
+// meetsMinTargetBalance checks the target holds at least MIN_TARGET_BALANCE. An address that isn't on chain
+// yet has nothing, so never meets a non-zero minimum.
+func meetsMinTargetBalance(ctx context.Context, addr address.Address, onChain bool) (bool, types.FIL, error) {
+	minimum := big.Int(env.MinTargetBalance)
+	if minimum.IsZero() {
+		return true, types.FIL(big.Zero()), nil
+	}
+	if !onChain {
+		return false, types.FIL(big.Zero()), nil
+	}
+	balance, err := lotusWalletBalance(ctx, addr)
+	if err != nil {
+		return false, types.FIL{}, err
+	}
+	return balance.GreaterThanEqual(minimum), types.FIL(balance), nil
+}
+
+// lotusIsMiner reports whether addr is a storage miner actor. Addresses not yet on chain aren't miners.
+func lotusIsMiner(ctx context.Context, lapi v0api.FullNode, addr address.Address) (bool, error) {
+	var act *types.Actor
+	err := traceRPC(ctx, "StateGetActor", func(ctx context.Context) (err error) {
+		act, err = lapi.StateGetActor(ctx, addr, types.EmptyTSK)
+		return err
+	})
+	if ignoreNotFound(err) != nil {
+		return false, err
+	} else if err != nil {
+		return false, nil
+	}
+	return lotusbuiltin.IsStorageMinerActor(act.Code), nil
+}
 
-	found, dcap, err := st.VerifierDataCap(vid)
+// lotusGetMinerPower returns the miner's current raw byte power
+func lotusGetMinerPower(ctx context.Context, lapi v0api.FullNode, minerAddr address.Address) (big.Int, error) {
+	var mp *api.MinerPower
+	err := traceRPC(ctx, "StateMinerPower", func(ctx context.Context) (err error) {
+		mp, err = lapi.StateMinerPower(ctx, minerAddr, types.EmptyTSK)
+		return err
+	})
 	if err != nil {
 		return big.Int{}, err
 	}
-	if !found {
-		return big.Int{}, errors.New("not found")
+	return mp.MinerPower.RawBytePower, nil
+}
+
+// lotusFaucetRecipient returns the address the faucet should fund for target. Miners are funded at the
+// address picked by env.FaucetMinerTarget, every other address is funded directly.
+func lotusFaucetRecipient(ctx context.Context, lapi v0api.FullNode, target address.Address, isMiner bool) (address.Address, error) {
+	if !isMiner || env.FaucetMinerTarget == MinerTargetActor || env.FaucetMinerTarget == "" {
+		return target, nil
 	}
 
-	return dcap, nil
+	var info miner.MinerInfo
+	err := traceRPC(ctx, "StateMinerInfo", func(ctx context.Context) (err error) {
+		info, err = lapi.StateMinerInfo(ctx, target, types.EmptyTSK)
+		return err
+	})
+	if err != nil {
+		return address.Undef, err
+	}
+
+	var recipient address.Address
+	switch env.FaucetMinerTarget {
+	case MinerTargetWorker:
+		recipient = info.Worker
+	case MinerTargetOwner:
+		recipient = info.Owner
+	default:
+		return address.Undef, errors.Errorf("unknown FAUCET_MINER_TARGET '%v'", env.FaucetMinerTarget)
+	}
+	if recipient == address.Undef {
+		return address.Undef, errors.Errorf("miner %v has no %v address", target, env.FaucetMinerTarget)
+	}
+	return recipient, nil
+}
+
+// lotusLookupIDAddress resolves addr to its ID address, returning address.Undef for addresses not on chain yet
+func lotusLookupIDAddress(ctx context.Context, lapi v0api.FullNode, addr address.Address) (address.Address, error) {
+	if addr.Protocol() == address.ID {
+		return addr, nil
+	}
+
+	var idAddr address.Address
+	err := traceRPC(ctx, "StateLookupID", func(ctx context.Context) (err error) {
+		idAddr, err = lapi.StateLookupID(ctx, addr, types.EmptyTSK)
+		return err
+	})
+	if ignoreNotFound(err) != nil {
+		return address.Undef, err
+	} else if err != nil {
+		return address.Undef, nil
+	}
+	return idAddr, nil
+}
+
+// lotusCanonicalAddress returns addr's ID address when it's on chain, so different forms of the same actor
+// compare equal. Pubkey (f1/f3) addresses can be verified before they appear on chain, the actor is created
+// when it first receives a message, so for those the robust form is returned with onChain false.
+// Any other address that isn't on chain can never become an actor and is rejected with ErrAddressNotOnChain.
+func lotusCanonicalAddress(ctx context.Context, addr address.Address) (canonical address.Address, onChain bool, err error) {
+	lapi, closer, err := lotusGetFullNodeAPI(ctx)
+	if err != nil {
+		return address.Undef, false, err
+	}
+	defer closer()
+
+	if addr.Protocol() == address.ID {
+		err := traceRPC(ctx, "StateGetActor", func(ctx context.Context) (err error) {
+			_, err = lapi.StateGetActor(ctx, addr, types.EmptyTSK)
+			return err
+		})
+		if ignoreNotFound(err) != nil {
+			return address.Undef, false, err
+		} else if err != nil {
+			return address.Undef, false, ErrAddressNotOnChain
+		}
+		return addr, true, nil
+	}
+
+	idAddr, err := lotusLookupIDAddress(ctx, lapi, addr)
+	if err != nil {
+		return address.Undef, false, err
+	}
+	if idAddr != address.Undef {
+		return idAddr, true, nil
+	}
+	if addr.Protocol() != address.SECP256K1 && addr.Protocol() != address.BLS {
+		return address.Undef, false, ErrAddressNotOnChain
+	}
+	return addr, false, nil
+}
+
+func addressStringOrEmpty(addr address.Address) string {
+	if addr == address.Undef {
+		return ""
+	}
+	return addr.String()
 }
 
 func lotusGetFullNodeAPI(ctx context.Context) (apiClient v0api.FullNode, closer jsonrpc.ClientCloser, err error) {
-	err = retry(ctx, func() error {
-		ainfo := cliutil.APIInfo{Token: []byte(env.LotusAPIToken)}
+	// Headers only go out when the client connects, and each operation connects on its own, so the
+	// node sees the caller's trace rather than one shared by every call
+	header := cliutil.APIInfo{Token: []byte(env.LotusAPIToken)}.AuthHeader()
+	if header == nil {
+		header = http.Header{}
+	}
+	injectTraceHeaders(ctx, header)
+
+	ctx, s := startSpan(ctx, "lotus.Connect", trace.SpanKindClient)
+	defer func() { finishSpan(s, err) }()
 
+	err = retry(ctx, func() error {
 		var innerErr error
-		apiClient, closer, innerErr = client.NewFullNodeRPCV0(ctx, env.LotusAPIDialAddr, ainfo.AuthHeader())
+		apiClient, closer, innerErr = client.NewFullNodeRPCV0(ctx, env.LotusAPIDialAddr, header)
 		return innerErr
 	})
 	return
 }
 
 func lotusSendFIL(ctx context.Context, lapi v0api.FullNode, fromAddr, toAddr address.Address, filAmount types.FIL) (cid.Cid, error) {
-	nonce, err := lapi.MpoolGetNonce(ctx, fromAddr)
+	var nonce uint64
+	err := traceRPC(ctx, "MpoolGetNonce", func(ctx context.Context) (err error) {
+		nonce, err = lapi.MpoolGetNonce(ctx, fromAddr)
+		return err
+	})
 	if err != nil {
 		return cid.Cid{}, err
 	}
@@ -269,26 +524,205 @@ func lotusSendFIL(ctx context.Context, lapi v0api.FullNode, fromAddr, toAddr add
 		Nonce: nonce,
 	}
 
-	sendSpec := &api.MessageSendSpec{
-		MaxFee: types.BigInt(env.MaxFee),
+	msgWithGas, err := lotusEstimateMessageGas(ctx, lapi, msg)
+	if err != nil {
+		return cid.Cid{}, err
+	}
+
+	mCid, err := lotusSignAndPush(ctx, lapi, msgWithGas)
+	rejection := lotusClassifyMpoolRejection(err)
+	if rejection == ErrMpoolGasTooLow && env.FaucetMpoolRetry {
+		// gas moved between estimating and pushing - re-estimate and retry once with a bumped premium
+		log.Println("faucet message rejected for low gas, retrying once:", redactLog(err.Error()))
+		msgWithGas, err = lotusEstimateMessageGas(ctx, lapi, msg)
+		if err != nil {
+			return cid.Cid{}, err
+		}
+		msgWithGas.GasPremium = types.BigDiv(types.BigMul(msgWithGas.GasPremium, types.NewInt(125)), types.NewInt(100))
+		if msgWithGas.GasFeeCap.LessThan(msgWithGas.GasPremium) {
+			msgWithGas.GasFeeCap = msgWithGas.GasPremium
+		}
+		capGasToMaxFee(msgWithGas)
+		mCid, err = lotusSignAndPush(ctx, lapi, msgWithGas)
+		rejection = lotusClassifyMpoolRejection(err)
+	}
+	if err != nil {
+		if rejection != nil {
+			return cid.Cid{}, errors.Wrap(rejection, err.Error())
+		}
+		return cid.Cid{}, errors.Wrap(err, "submitting message")
 	}
+	return mCid, nil
+}
 
-	msgWithGas, err := lapi.GasEstimateMessageGas(ctx, msg, sendSpec, types.EmptyTSK)
+// lotusSignAndPush signs msg and pushes it, returning the signed message CID (SignedMessage.Cid). For our secp256k1
+// wallets that differs from msg.Cid(), and it's the one StateSearchMsg, StateWaitMsg and explorers key on - the
+// unsigned CID of a secp message is never included on chain. For BLS messages the two are the same.
+func lotusSignAndPush(ctx context.Context, lapi v0api.FullNode, msg *types.Message) (cid.Cid, error) {
+	sig, err := walletSignMessage(ctx, msg.From, msg.Cid().Bytes(), api.MsgMeta{Type: api.MTUnknown})
 	if err != nil {
 		return cid.Cid{}, err
 	}
-	sig, err := walletSignMessage(ctx, fromAddr, msgWithGas.Cid().Bytes(), api.MsgMeta{Type: api.MTUnknown})
+	smsg := &types.SignedMessage{Signature: *sig, Message: *msg}
+
+	// A pending message with the same nonce gets replaced by this one if the mpool accepts it
+	prior, err := lotusPendingMessageWithNonce(ctx, lapi, msg.From, msg.Nonce)
 	if err != nil {
-		return cid.Cid{}, err
+		log.Println("error checking mpool for a pending message with nonce", msg.Nonce, err)
 	}
 
-	mCid, err := lapi.MpoolPush(ctx, &types.SignedMessage{Signature: *sig, Message: *msgWithGas})
+	var mCid cid.Cid
+	err = traceRPC(ctx, "MpoolPush", func(ctx context.Context) (err error) {
+		mCid, err = lapi.MpoolPush(ctx, smsg)
+		return err
+	})
 	if err != nil {
 		return cid.Cid{}, err
 	}
+	// MpoolPush returns smsg.Cid() as well, flag it if a node ever disagrees since that's the CID users will search for
+	if mCid != smsg.Cid() {
+		log.Println("MpoolPush returned", mCid, "for signed message", smsg.Cid())
+	}
+	if prior != nil && prior.Cid() != mCid {
+		reportReplacedMessage(prior.Cid(), mCid, msg.Nonce)
+	}
 	return mCid, nil
 }
 
+// lotusPendingMessageWithNonce returns the sender's pending mpool message with the nonce, or nil if there isn't one.
+// There's no call listing one sender's pending messages, so the sender's next nonce is checked first and the whole
+// mpool is only fetched when the nonce is being reused, not on every push.
+func lotusPendingMessageWithNonce(ctx context.Context, lapi v0api.FullNode, from address.Address, nonce uint64) (*types.SignedMessage, error) {
+	var next uint64
+	err := traceRPC(ctx, "MpoolGetNonce", func(ctx context.Context) (err error) {
+		next, err = lapi.MpoolGetNonce(ctx, from)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the next nonce counts the sender's pending messages, so nothing pending can have this one
+	if nonce >= next {
+		return nil, nil
+	}
+
+	var pending []*types.SignedMessage
+	err = traceRPC(ctx, "MpoolPending", func(ctx context.Context) (err error) {
+		pending, err = lapi.MpoolPending(ctx, types.EmptyTSK)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, smsg := range pending {
+		if smsg.Message.From == from && smsg.Message.Nonce == nonce {
+			return smsg, nil
+		}
+	}
+	return nil, nil
+}
+
+// reportReplacedMessage flags a pending message that was pushed out of the mpool by a new message with its nonce.
+// Whoever was waiting on the old CID will never see it land, so its transaction record is pointed at the new one.
+func reportReplacedMessage(oldCid, newCid cid.Cid, nonce uint64) {
+	mpoolNonceReplacements.Add(1)
+	log.Println("message", oldCid, "was replaced in the mpool by", newCid, "reusing nonce", nonce)
+	sendSlackNotification("https://errors.glif.io/mpool-message-replaced", "MPOOL MESSAGE REPLACED: "+oldCid.String()+" by "+newCid.String()+" (nonce "+fmt.Sprint(nonce)+")")
+	if err := markTransactionReplaced(oldCid.String(), newCid.String()); err != nil && !isCondCheckFailed(err) {
+		log.Println("error marking transaction replaced:", oldCid, err)
+	}
+}
+
+// lotusClassifyMpoolRejection maps the mpool's rejection messages onto our categories, or nil if it's something else.
+// The mpool only gives us strings over the RPC, so this matches on lotus' error wording.
+func lotusClassifyMpoolRejection(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "not enough funds"):
+		return ErrFaucetOutOfFunds
+	case strings.Contains(msg, "gas fee cap too low"), strings.Contains(msg, "too low GasPremium"):
+		return ErrMpoolGasTooLow
+	case strings.Contains(msg, "already in mpool"), strings.Contains(msg, "minimum expected nonce"):
+		return ErrMpoolDuplicateNonce
+	}
+	return nil
+}
+
+// lotusEstimateMessageGas fills in the gas fields of msg. The estimate is advisory only - it reflects the
+// mpool at the time of the call, and the message can still be repriced or fail once submitted.
+// A sender with no prior messages (nonce 0) gives noisy estimates, so GasEstimationFallbackAddr is
+// used as the estimation sender in that case when it's configured. Only for plain value sends though,
+// an actor method like AddVerifiedClient fails when estimated from anyone but the real sender.
+// Failures are wrapped in ErrGasEstimationFailed so handlers can tell them apart from submission failures.
+func lotusEstimateMessageGas(ctx context.Context, lapi v0api.FullNode, msg *types.Message) (*types.Message, error) {
+	sendSpec := &api.MessageSendSpec{
+		MaxFee: types.BigInt(env.MaxFee),
+	}
+
+	estimateMsg := *msg
+	if msg.Nonce == 0 && msg.Method == builtin.MethodSend && env.GasEstimationFallbackAddr != address.Undef {
+		estimateMsg.From = env.GasEstimationFallbackAddr
+	}
+
+	var estimated *types.Message
+	err := traceRPC(ctx, "GasEstimateMessageGas", func(ctx context.Context) (err error) {
+		estimated, err = lapi.GasEstimateMessageGas(ctx, &estimateMsg, sendSpec, types.EmptyTSK)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(ErrGasEstimationFailed, err.Error())
+	}
+
+	msgWithGas := *msg
+	msgWithGas.GasLimit = estimated.GasLimit
+	msgWithGas.GasFeeCap = estimated.GasFeeCap
+	msgWithGas.GasPremium = estimated.GasPremium
+	applyMinGasPremium(&msgWithGas)
+	return &msgWithGas, nil
+}
+
+// applyMinGasPremium raises a near-zero estimated premium to MIN_GAS_PREMIUM (attoFIL per gas unit), so quiet
+// periods don't produce messages nobody bothers to mine. With MAX_FEE set the floor never takes the premium
+// past what the fee ceiling allows for the message's gas limit.
+func applyMinGasPremium(msg *types.Message) {
+	floor := big.Int(env.MinGasPremium)
+	if floor.IsZero() || msg.GasPremium.GreaterThanEqual(floor) {
+		return
+	}
+	if maxFee := big.Int(env.MaxFee); !maxFee.IsZero() && msg.GasLimit > 0 {
+		ceiling := big.Div(maxFee, big.NewInt(msg.GasLimit))
+		if floor.GreaterThan(ceiling) {
+			floor = ceiling
+		}
+	}
+	if msg.GasPremium.GreaterThanEqual(floor) {
+		return
+	}
+	msg.GasPremium = floor
+	if msg.GasFeeCap.LessThan(msg.GasPremium) {
+		msg.GasFeeCap = msg.GasPremium
+	}
+}
+
+// capGasToMaxFee holds a bumped fee cap to env.MaxFee spread over the gas limit, like applyMinGasPremium,
+// and keeps the premium at or below the fee cap
+func capGasToMaxFee(msg *types.Message) {
+	maxFee := big.Int(env.MaxFee)
+	if maxFee.IsZero() || msg.GasLimit <= 0 {
+		return
+	}
+	ceiling := big.Div(maxFee, big.NewInt(msg.GasLimit))
+	if msg.GasFeeCap.GreaterThan(ceiling) {
+		msg.GasFeeCap = ceiling
+	}
+	if msg.GasPremium.GreaterThan(msg.GasFeeCap) {
+		msg.GasPremium = msg.GasFeeCap
+	}
+}
+
 var errNotMiner = errors.New("not a miner")
 
 func lotusTranslateError(err *error) {
@@ -300,6 +734,21 @@ func lotusTranslateError(err *error) {
 	}
 }
 
+func lotusGetMessage(ctx context.Context, msgCid cid.Cid) (*types.Message, error) {
+	client, closer, err := lotusGetFullNodeAPI(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	var msg *types.Message
+	err = traceRPC(ctx, "ChainGetMessage", func(ctx context.Context) (err error) {
+		msg, err = client.ChainGetMessage(ctx, msgCid)
+		return err
+	})
+	return msg, err
+}
+
 func lotusSearchMessageResult(ctx context.Context, cid cid.Cid) (*api.MsgLookup, error) {
 	client, closer, err := lotusGetFullNodeAPI(ctx)
 	if err != nil {
@@ -309,7 +758,10 @@ func lotusSearchMessageResult(ctx context.Context, cid cid.Cid) (*api.MsgLookup,
 	defer closer()
 
 	var mLookup *api.MsgLookup
-	mLookup, err = client.StateSearchMsg(ctx, cid)
+	err = traceRPC(ctx, "StateSearchMsg", func(ctx context.Context) (err error) {
+		mLookup, err = client.StateSearchMsg(ctx, cid)
+		return err
+	})
 	if err != nil {
 		return &api.MsgLookup{}, err
 	}
@@ -317,7 +769,56 @@ func lotusSearchMessageResult(ctx context.Context, cid cid.Cid) (*api.MsgLookup,
 	return mLookup, nil
 }
 
+type messageSearchResult struct {
+	lookup *api.MsgLookup
+	err    error
+}
+
+// lotusSearchMessages looks up many messages at once for the reconcile jobs, over a single node connection with at
+// most MESSAGE_SEARCH_CONCURRENCY StateSearchMsg calls in flight. Results line up with cids, a nil lookup
+// means the message isn't on chain (yet).
+func lotusSearchMessages(ctx context.Context, cids []cid.Cid) []messageSearchResult {
+	results := make([]messageSearchResult, len(cids))
+	if len(cids) == 0 {
+		return results
+	}
+
+	client, closer, err := lotusGetFullNodeAPI(ctx)
+	if err != nil {
+		log.Println("error getting FullNodeAPI:", err)
+		for i := range results {
+			results[i].err = err
+		}
+		return results
+	}
+	defer closer()
+
+	concurrency := env.MessageSearchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, msgCid := range cids {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, msgCid cid.Cid) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i].err = traceRPC(ctx, "StateSearchMsg", func(ctx context.Context) (err error) {
+				results[i].lookup, err = client.StateSearchMsg(ctx, msgCid)
+				return err
+			})
+		}(i, msgCid)
+	}
+	wg.Wait()
+	return results
+}
+
+// retry calls fn until it succeeds, backing off between attempts, until ctx is done or the request's
+// retry budget runs out. The last error is returned in either case.
 func retry(ctx context.Context, fn func() error) (err error) {
+	budget := retryBudgetFromContext(ctx)
 	wait := 5 * time.Second
 	for {
 		select {
@@ -327,12 +828,19 @@ func retry(ctx context.Context, fn func() error) (err error) {
 		}
 
 		err = fn()
-		if err != nil {
-			time.Sleep(wait)
-			wait += wait / 2
-			continue
+		if err == nil {
+			return nil
 		}
-		return nil
+		if !budget.spend(wait) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		wait += wait / 2
 	}
 }
 
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type providerMetadataRequirement struct {
+	Provider string
+	Key      string
+	Minimum  float64
+}
+
+var providerMetadataRequirements []providerMetadataRequirement
+
+// parse the provider metadata minimums once at startup so a bad config fails loudly
+func initProviderMetadataRequirements() error {
+	reqs, err := parseProviderMetadataValues(env.VerifierMinProviderMetadata)
+	if err != nil {
+		return errors.Wrap(err, "parsing VERIFIER_MIN_PROVIDER_METADATA")
+	}
+	for _, req := range reqs {
+		fmt.Println("Requiring provider metadata " + req.Provider + "." + req.Key + " >= " + fmt.Sprint(req.Minimum))
+	}
+	providerMetadataRequirements = reqs
+	return nil
+}
+
+// parseProviderMetadataValues parses comma separated "provider.key=number" pairs
+func parseProviderMetadataValues(raw string) ([]providerMetadataRequirement, error) {
+	var reqs []providerMetadataRequirement
+	if len(raw) == 0 {
+		return reqs, nil
+	}
+
+	for _, e := range strings.Split(raw, ",") {
+		parts := strings.SplitN(strings.TrimSpace(e), "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("expected provider.key=number, got '%v'", e)
+		}
+		name := strings.SplitN(parts[0], ".", 2)
+		if len(name) != 2 {
+			return nil, errors.Errorf("expected provider.key=number, got '%v'", e)
+		}
+		minimum, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing '%v'", e)
+		}
+		reqs = append(reqs, providerMetadataRequirement{Provider: name[0], Key: name[1], Minimum: minimum})
+	}
+	return reqs, nil
+}
+
+// providerMetadataNumber returns the numeric value of a provider metadata key, or false if it's missing or not a number
+func (user User) providerMetadataNumber(provider, key string) (float64, bool) {
+	account, exists := user.Accounts[provider]
+	if !exists {
+		return 0, false
+	}
+	raw, exists := account.ProviderMetadata[key]
+	if !exists {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// MeetsProviderMetadataRequirements reports whether the user's linked accounts satisfy every configured minimum
+func (user User) MeetsProviderMetadataRequirements() bool {
+	for _, req := range providerMetadataRequirements {
+		n, ok := user.providerMetadataNumber(req.Provider, req.Key)
+		if !ok || n < req.Minimum {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"expvar"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics are published with expvar and served as JSON from /metrics.
+// Latencies are kept as a running total and count per key, so averages can be derived.
+var (
+	oauthExchangeFailures  = expvar.NewMap("oauth_exchange_failures")
+	oauthExchangeRejected  = expvar.NewMap("oauth_exchange_rejected_busy")
+	oauthExchangeCount     = expvar.NewMap("oauth_exchange_count")
+	oauthExchangeLatencyMs = expvar.NewMap("oauth_exchange_latency_ms_total")
+	verifyQueueDepth       = expvar.NewInt("verify_queue_depth")
+	verifyQueueRejected    = expvar.NewInt("verify_queue_rejected_full")
+	mpoolNonceReplacements = expvar.NewInt("mpool_nonce_replacements")
+	clockSkewSeconds       = expvar.NewInt("clock_skew_seconds")
+	// oauth_logins counts successful logins per provider, split into new and existing users below
+	oauthLogins        = expvar.NewMap("oauth_logins")
+	oauthNewUsers      = expvar.NewMap("oauth_logins_new_user")
+	oauthExistingUsers = expvar.NewMap("oauth_logins_existing_user")
+	// cumulative buckets keyed "<provider>.le_<ms>", see observeHistogram
+	oauthFetchLatency = expvar.NewMap("oauth_fetch_account_latency_ms")
+)
+
+// oauthFetchLatencyBuckets are the upper bounds, in milliseconds, of the oauth_fetch_account_latency_ms buckets
+var oauthFetchLatencyBuckets = []int64{50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+func observeLatency(count, total *expvar.Map, key string, start time.Time) {
+	count.Add(key, 1)
+	total.Add(key, time.Since(start).Milliseconds())
+}
+
+// observeHistogram adds the time since start to every bucket it falls under, Prometheus style,
+// so "<key>.le_250" is the number of observations that took at most 250ms and "<key>.le_inf" is all of them
+func observeHistogram(histogram *expvar.Map, key string, buckets []int64, start time.Time) {
+	elapsed := time.Since(start).Milliseconds()
+	for _, bound := range buckets {
+		if elapsed <= bound {
+			histogram.Add(key+".le_"+strconv.FormatInt(bound, 10), 1)
+		}
+	}
+	histogram.Add(key+".le_inf", 1)
+}
+
+func serveMetrics() gin.HandlerFunc {
+	return gin.WrapH(expvar.Handler())
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/gin-gonic/gin"
+)
+
+// serveMinerInfo shows the miner the faucet would fund, with everything the funding flow needs in one call
+func serveMinerInfo(c *gin.Context) {
+	addr, err := address.NewFromString(c.Param("addr"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(tracedBackground(c), 30*time.Second)
+	defer cancel()
+
+	lapi, closer, err := lotusGetFullNodeAPI(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer closer()
+
+	isMiner, err := lotusIsMiner(ctx, lapi, addr)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if !isMiner {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not a miner", "isMiner": false})
+		return
+	}
+
+	var info miner.MinerInfo
+	err = traceRPC(ctx, "StateMinerInfo", func(ctx context.Context) (err error) {
+		info, err = lapi.StateMinerInfo(ctx, addr, types.EmptyTSK)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var mp *api.MinerPower
+	err = traceRPC(ctx, "StateMinerPower", func(ctx context.Context) (err error) {
+		mp, err = lapi.StateMinerPower(ctx, addr, types.EmptyTSK)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	controlAddresses := []string{}
+	for _, control := range info.ControlAddresses {
+		controlAddresses = append(controlAddresses, control.String())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"isMiner":          true,
+		"miner":            addr.String(),
+		"owner":            info.Owner.String(),
+		"worker":           info.Worker.String(),
+		"controlAddresses": controlAddresses,
+		"rawBytePower":     mp.MinerPower.RawBytePower.String(),
+		"qualityAdjPower":  mp.MinerPower.QualityAdjPower.String(),
+		"sectorSize":       uint64(info.SectorSize),
+		"sectorSizeHuman":  info.SectorSize.ShortString(),
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// AllocationNotification is POSTed to every ALLOCATION_WEBHOOK_URLS recipient once an allocation lands on chain.
+// Handles, the user's provider usernames, are only included with ALLOCATION_NOTIFY_PII.
+type AllocationNotification struct {
+	Event         string            `json:"event"`
+	Cid           string            `json:"cid"`
+	Address       string            `json:"address"`
+	Allowance     string            `json:"allowance,omitempty"`
+	Verifier      string            `json:"verifier"`
+	UserID        string            `json:"userID"`
+	Handles       map[string]string `json:"handles,omitempty"`
+	ConfirmedAt   time.Time         `json:"confirmedAt"`
+	CorrelationID string            `json:"correlationId,omitempty"`
+}
+
+func allocationWebhookURLs() []string {
+	var urls []string
+	for _, url := range strings.Split(env.AllocationWebhookURLs, ",") {
+		if url = strings.TrimSpace(url); len(url) > 0 {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// notifyAllocation fans a confirmed allocation out to the configured recipients. Failures are only logged,
+// a recipient being down doesn't hold up the cron job.
+func notifyAllocation(user User) {
+	urls := allocationWebhookURLs()
+	if len(urls) == 0 {
+		return
+	}
+
+	notification := AllocationNotification{
+		Event:       "allocation.confirmed",
+		Cid:         user.MostRecentDataCapCid,
+		Address:     user.MostRecentVerifiedAddress,
+		Verifier:    user.MostRecentVerifierAddress,
+		UserID:      user.ID,
+		ConfirmedAt: time.Now(),
+	}
+	if transactionsEnabled() {
+		if tx, err := getTransaction(user.MostRecentDataCapCid); err == nil {
+			notification.Allowance = tx.Amount
+			notification.CorrelationID = tx.CorrelationID
+		}
+	}
+	if env.AllocationNotifyPII {
+		notification.Handles = map[string]string{}
+		for provider, account := range user.Accounts {
+			notification.Handles[provider] = account.Username
+		}
+	}
+
+	for _, url := range urls {
+		go func(url string) {
+			if err := postWebhook(url, notification); err != nil {
+				log.Println("error sending allocation notification:", notification.Cid, err)
+			}
+		}(url)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"time"
 )
 
 type OAuthProvider struct {
@@ -13,6 +14,9 @@ type OAuthProvider struct {
 	ClientSecret     string
 	TokenEndpoint    string
 	FetchAccountData func(token string) (AccountData, error)
+	// AccountCreatedAt is the provider's strategy for when an account was opened, false when it can't say.
+	// Nil for providers with no source at all, their accounts are always handled by UNKNOWN_ACCOUNT_AGE.
+	AccountCreatedAt func(account AccountData) (time.Time, bool)
 }
 
 var oauthProviders = map[string]OAuthProvider{}
@@ -21,6 +25,21 @@ func RegisterOAuthProvider(name string, provider OAuthProvider) {
 	oauthProviders[name] = provider
 }
 
+// createdAtFromAccountData is the age strategy for providers that report a creation date in their account
+// API, which FetchAccountData copies into AccountData.CreatedAt
+func createdAtFromAccountData(account AccountData) (time.Time, bool) {
+	return account.CreatedAt, !account.CreatedAt.IsZero()
+}
+
+// accountCreatedAt runs the linked account's provider age strategy
+func accountCreatedAt(providerName string, account AccountData) (time.Time, bool) {
+	provider, ok := oauthProviders[providerName]
+	if !ok || provider.AccountCreatedAt == nil {
+		return time.Time{}, false
+	}
+	return provider.AccountCreatedAt(account)
+}
+
 func OAuthExchangeCodeForToken(provider OAuthProvider, code, state string) (string, error) {
 	var (
 		buf    = &bytes.Buffer{}
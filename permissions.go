@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Permission is something a user JWT can authorize, carried space separated in its "scope" claim
+type Permission string
+
+const (
+	// PermissionVerify allows requesting DataCap
+	PermissionVerify Permission = "verify"
+	// PermissionFaucet allows requesting FIL from the faucet
+	PermissionFaucet Permission = "faucet"
+	// PermissionRead allows the read-only user endpoints, like eligibility and history
+	PermissionRead Permission = "read"
+)
+
+var allPermissions = []Permission{PermissionVerify, PermissionFaucet, PermissionRead}
+
+// jwtPermissions reads the scope claim. Tokens without one, which includes every login token, get all permissions.
+func jwtPermissions(claims jwt.MapClaims) map[Permission]bool {
+	permissions := map[Permission]bool{}
+	scope, ok := claims["scope"].(string)
+	if !ok {
+		for _, p := range allPermissions {
+			permissions[p] = true
+		}
+		return permissions
+	}
+	for _, p := range strings.Fields(scope) {
+		permissions[Permission(p)] = true
+	}
+	return permissions
+}
+
+// signUserJWT issues a token for the user, restricted to permissions when any are given
+func signUserJWT(userID string, permissions []Permission) (string, error) {
+	claims := jwt.MapClaims{
+		"userID": userID,
+		"nbf":    time.Date(2015, 10, 10, 12, 0, 0, 0, time.UTC).Unix(),
+	}
+	if len(permissions) > 0 {
+		claims["scope"] = joinPermissions(permissions)
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(env.JWTSecret))
+}
+
+// joinPermissions formats permissions as a space separated scope
+func joinPermissions(permissions []Permission) string {
+	scope := make([]string, len(permissions))
+	for i, p := range permissions {
+		scope[i] = string(p)
+	}
+	return strings.Join(scope, " ")
+}
+
+// getUserPermissionsFromJWT is getUserIDFromJWT for the token's permissions
+func getUserPermissionsFromJWT(c *gin.Context) (map[Permission]bool, error) {
+	if permissions, ok := c.Get("permissions"); ok {
+		return permissions.(map[Permission]bool), nil
+	}
+	_, claims, err := parseAuthorizationHeader(c)
+	if err != nil {
+		return nil, err
+	}
+	return jwtPermissions(claims), nil
+}
+
+// requirePermission rejects user JWTs without the permission. Requests without a valid JWT are passed on,
+// so the handler still answers them with its usual error.
+func requirePermission(permission Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		permissions, err := getUserPermissionsFromJWT(c)
+		if err != nil {
+			return
+		}
+		if !permissions[permission] {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": ErrMissingPermission.Error(), "permission": permission})
+		}
+	}
+}
+
+// serveMintUserToken issues a restricted JWT for a user, e.g. a partner integration that only reads
+func serveMintUserToken(c *gin.Context) {
+	type Request struct {
+		Permissions []Permission `json:"permissions" binding:"required,min=1"`
+	}
+	var body Request
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, bindingErrorBody(err))
+		return
+	}
+	for _, p := range body.Permissions {
+		if p != PermissionVerify && p != PermissionFaucet && p != PermissionRead {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errors.Errorf("unknown permission %q", p).Error()})
+			return
+		}
+	}
+
+	userID := c.Param("id")
+	if _, err := getUserByID(userID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	token, err := signUserJWT(userID, body.Permissions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, AuditAction_MintUserToken, userID, map[string]string{"permissions": joinPermissions(body.Permissions)})
+	c.JSON(http.StatusOK, gin.H{"jwt": token})
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -16,6 +17,8 @@ func init() {
 		ClientID:      env.GithubClientID,
 		ClientSecret:  env.GithubClientSecret,
 		TokenEndpoint: "https://github.com/login/oauth/access_token",
+		// created_at from GET /user
+		AccountCreatedAt: createdAtFromAccountData,
 		FetchAccountData: func(token string) (AccountData, error) {
 			resp, err := githubMakeAuthorizedRequest("https://api.github.com/user", token)
 			if err != nil {
@@ -24,10 +27,12 @@ func init() {
 			defer resp.Close()
 
 			type GithubAccountData struct {
-				ID        uint      `json:"id"`
-				Name      string    `json:"name"`
-				Username  string    `json:"login"`
-				CreatedAt time.Time `json:"created_at"`
+				ID          uint      `json:"id"`
+				Name        string    `json:"name"`
+				Username    string    `json:"login"`
+				CreatedAt   time.Time `json:"created_at"`
+				Followers   uint      `json:"followers"`
+				PublicRepos uint      `json:"public_repos"`
 			}
 
 			var user GithubAccountData
@@ -45,12 +50,55 @@ func init() {
 				Username:  user.Username,
 				Name:      user.Name,
 				CreatedAt: user.CreatedAt,
+				ProviderMetadata: map[string]string{
+					"followers":    fmt.Sprintf("%v", user.Followers),
+					"public_repos": fmt.Sprintf("%v", user.PublicRepos),
+				},
+			}
+
+			// emails need the user:email scope, so only ask for them when a deployment restricts domains
+			if allowedEmailDomains() != nil {
+				email, err := githubFetchPrimaryVerifiedEmail(token)
+				if err != nil {
+					log.Println("error fetching Github emails:", err)
+				} else {
+					accountData.Email = email
+					accountData.EmailVerified = email != ""
+				}
 			}
 			return accountData, nil
 		},
 	})
 }
 
+// githubFetchPrimaryVerifiedEmail returns the user's primary email if Github has verified it, otherwise ""
+func githubFetchPrimaryVerifiedEmail(token string) (string, error) {
+	resp, err := githubMakeAuthorizedRequest("https://api.github.com/user/emails", token)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Close()
+
+	type GithubEmail struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+
+	var emails []GithubEmail
+	err = json.NewDecoder(resp).Decode(&emails)
+	if err != nil {
+		return "", err
+	}
+
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			return email.Email, nil
+		}
+	}
+	return "", nil
+}
+
 func githubMakeAuthorizedRequest(url, token string) (io.ReadCloser, error) {
 	var client http.Client
 
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/gin-gonic/gin"
+)
+
+// serveVerifyQuote runs the same checks and allowance math as serveVerifyAccount without locking, counting or
+// sending anything, so the frontend can show what a verify would do before the user commits to it.
+// Unlike serveVerifyAccount every check runs, and each failing one is listed in blockingReasons.
+func serveVerifyQuote(c *gin.Context) {
+	userID, err := getUserIDFromJWT(c)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := getUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": ErrStaleJWT.Error()})
+		return
+	}
+	if len(user.Accounts) == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": ErrNoLinkedAccounts.Error(), "code": "NO_LINKED_ACCOUNTS"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(tracedBackground(c), 2*time.Minute)
+	defer cancel()
+
+	targetAddrStr := c.Param("target_addr")
+	plan, failed := checkVerifyRequest(ctx, c, user, targetAddrStr, verifyCheckQuote)
+	if failed != nil {
+		c.JSON(failed.Status, failed.Body)
+		return
+	}
+
+	type Response struct {
+		Eligible          bool       `json:"eligible"`
+		BlockingReasons   []string   `json:"blockingReasons"`
+		Verifier          string     `json:"verifier"`
+		RemainingBytes    string     `json:"remainingBytes,omitempty"`
+		Owed              string     `json:"owed,omitempty"`
+		Allowance         string     `json:"allowance,omitempty"`
+		Partial           bool       `json:"partial"`
+		RequiresReview    bool       `json:"requiresReview"`
+		EstimatedGasFee   string     `json:"estimatedGasFee,omitempty"`
+		NextEligibleAt    *time.Time `json:"nextEligibleAt,omitempty"`
+		MaxAllowanceBytes string     `json:"maxAllowanceBytes"`
+	}
+	resp := Response{
+		Eligible:          len(plan.Refusals) == 0,
+		BlockingReasons:   []string{},
+		Verifier:          VerifierAddr.String(),
+		RemainingBytes:    plan.ClientRemaining.String(),
+		Partial:           plan.Partial,
+		NextEligibleAt:    plan.NextEligibleAt,
+		MaxAllowanceBytes: env.MaxAllowanceBytes.String(),
+	}
+	for _, refusal := range plan.Refusals {
+		resp.BlockingReasons = append(resp.BlockingReasons, refusal.message())
+	}
+	if plan.Owed.GreaterThan(types.NewInt(0)) {
+		resp.Owed = plan.Owed.String()
+	}
+
+	if plan.Allowance.GreaterThan(types.NewInt(0)) {
+		resp.Allowance = plan.Allowance.String()
+		resp.RequiresReview = requiresManualReview(plan.Allowance)
+
+		// the fee is informational, a failed estimate doesn't block the quote
+		if fee, err := lotusEstimateVerifyFee(ctx, targetAddrStr, plan.Allowance); err == nil {
+			resp.EstimatedGasFee = types.FIL(fee).String()
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin/verifreg"
+	"github.com/gin-gonic/gin"
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+)
+
+// Receipt is a self-contained record of a landed verify or faucet message, meant for users to archive
+type Receipt struct {
+	Cid         string          `json:"cid"`
+	Type        TransactionType `json:"type"`
+	From        string          `json:"from"`
+	To          string          `json:"to"`
+	Recipient   string          `json:"recipient"`
+	Amount      string          `json:"amount"`
+	Method      uint64          `json:"method"`
+	Params      interface{}     `json:"params,omitempty"`
+	ExitCode    int64           `json:"exitCode"`
+	GasUsed     int64           `json:"gasUsed"`
+	Height      int64           `json:"height"`
+	TipSet      []string        `json:"tipset"`
+	GeneratedAt time.Time       `json:"generatedAt"`
+}
+
+func serveVerifyReceipt(c *gin.Context) {
+	serveReceipt(c, TransactionType_Verify, VerifierAddr)
+}
+
+func serveFaucetReceipt(c *gin.Context) {
+	serveReceipt(c, TransactionType_Faucet, FaucetAddr)
+}
+
+// serveReceipt only describes messages sent by our own verifier or faucet, it's not a general chain explorer
+func serveReceipt(c *gin.Context, txType TransactionType, sender address.Address) {
+	msgCid, err := cid.Decode(c.Param("cid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message cid"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(tracedBackground(c), 30*time.Second)
+	defer cancel()
+
+	msg, err := lotusGetMessage(ctx, msgCid)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+	if msg.From != sender || (txType == TransactionType_Verify && msg.Method != builtin.MethodsVerifiedRegistry.AddVerifiedClient) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+
+	mLookup, err := lotusSearchMessageResult(ctx, msgCid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if mLookup == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message has not landed on chain yet"})
+		return
+	}
+
+	receipt, err := newReceipt(msgCid, txType, msg, mLookup)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="`+string(txType)+"-receipt-"+receipt.Cid+`.json"`)
+	c.IndentedJSON(http.StatusOK, receipt)
+}
+
+// newReceipt describes a landed message, decoding the AddVerifiedClient params of a verify
+func newReceipt(msgCid cid.Cid, txType TransactionType, msg *types.Message, mLookup *api.MsgLookup) (Receipt, error) {
+	receipt := Receipt{
+		Cid:         msgCid.String(),
+		Type:        txType,
+		From:        msg.From.String(),
+		To:          msg.To.String(),
+		Recipient:   msg.To.String(),
+		Amount:      types.FIL(msg.Value).String(),
+		Method:      uint64(msg.Method),
+		ExitCode:    int64(mLookup.Receipt.ExitCode),
+		GasUsed:     mLookup.Receipt.GasUsed,
+		Height:      int64(mLookup.Height),
+		GeneratedAt: time.Now(),
+	}
+	for _, tsCid := range mLookup.TipSet.Cids() {
+		receipt.TipSet = append(receipt.TipSet, tsCid.String())
+	}
+
+	if txType == TransactionType_Verify {
+		var params verifreg.AddVerifiedClientParams
+		if err := params.UnmarshalCBOR(bytes.NewReader(msg.Params)); err != nil {
+			return Receipt{}, errors.Wrap(err, "decoding message params")
+		}
+		receipt.Recipient = params.Address.String()
+		receipt.Amount = params.Allowance.String()
+		receipt.Params = gin.H{"address": params.Address.String(), "allowance": params.Allowance.String()}
+	}
+	return receipt, nil
+}
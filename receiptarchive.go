@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+)
+
+// Confirmed allocations are archived to RECEIPT_S3_BUCKET as their Receipt JSON, one object per message at
+// <RECEIPT_S3_PREFIX><cid>.json, so they outlive the app's tables and can be queried with Athena.
+
+// how long archiveReceipt keeps retrying one receipt before giving up
+const receiptArchiveTimeout = 30 * time.Minute
+
+var (
+	receiptS3Client *s3.S3
+	receiptS3Once   sync.Once
+)
+
+// receiptS3 is built on first use rather than at init, awsConfig needs env loaded
+func receiptS3() *s3.S3 {
+	receiptS3Once.Do(func() {
+		receiptS3Client = s3.New(session.Must(session.NewSession(awsConfig())))
+	})
+	return receiptS3Client
+}
+
+func receiptArchiveEnabled() bool {
+	return len(env.ReceiptS3Bucket) > 0
+}
+
+func receiptArchiveKey(msgCid cid.Cid) string {
+	prefix := env.ReceiptS3Prefix
+	if len(prefix) > 0 && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix + msgCid.String() + ".json"
+}
+
+// archiveReceipt writes the receipt of a confirmed allocation to S3 in the background, retrying until
+// receiptArchiveTimeout. Like notifyAllocation a failure is only logged, it never holds up the cron job.
+func archiveReceipt(msgCid cid.Cid, mLookup *api.MsgLookup) {
+	if !receiptArchiveEnabled() {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(backgroundCtx, receiptArchiveTimeout)
+		defer cancel()
+
+		err := retry(ctx, func() error {
+			return putReceipt(ctx, msgCid, mLookup)
+		})
+		if err != nil {
+			log.Println("error archiving receipt:", msgCid, err)
+		}
+	}()
+}
+
+func putReceipt(ctx context.Context, msgCid cid.Cid, mLookup *api.MsgLookup) error {
+	msg, err := lotusGetMessage(ctx, msgCid)
+	if err != nil {
+		return errors.Wrap(err, "getting message")
+	}
+	receipt, err := newReceipt(msgCid, TransactionType_Verify, msg, mLookup)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(receipt)
+	if err != nil {
+		return err
+	}
+
+	_, err = receiptS3().PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(env.ReceiptS3Bucket),
+		Key:         aws.String(receiptArchiveKey(msgCid)),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	return errors.Wrap(err, "putting receipt")
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+)
+
+// Matches ID addresses and the base32 secp256k1/actor/BLS forms, on either network
+var filecoinAddressPattern = regexp.MustCompile(`\b[ft](0[0-9]+|[1-3][a-z2-7]{10,})\b`)
+
+// logAddr is how addresses should be written to logs. With REDACT_ADDRESSES_IN_LOGS each address is
+// replaced by a short hash, the same for every line it appears in, so logs can still be correlated.
+// Responses and transaction records always keep the full address.
+func logAddr(addr string) string {
+	if !env.RedactAddressesInLogs {
+		return addr
+	}
+	sum := sha256.Sum256([]byte(env.LogRedactionSalt + addr))
+	return "addr:" + hex.EncodeToString(sum[:6])
+}
+
+// redactLog applies logAddr to every address in a log message, for errors that embed addresses
+func redactLog(msg string) string {
+	if !env.RedactAddressesInLogs {
+		return msg
+	}
+	return filecoinAddressPattern.ReplaceAllStringFunc(msg, logAddr)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// runtimeSettings are the settings that can change without a restart. Handlers read them through settings(),
+// everything else in env is restart-only. A reload builds a whole new runtimeSettings and swaps it in,
+// so a request never sees half of one reload.
+//
+// Reloadable: VERIFIER_RATE_LIMIT, FAUCET_RATE_LIMIT, MAX_TOTAL_ALLOCATIONS, MAX_ALLOWANCE_PER_WINDOW,
+// FAUCET_GRANT_SIZE, FAUCET_MAX_GRANT, MIN_TRUST_SCORE and BLOCKED_ADDRESSES.
+type runtimeSettings struct {
+	VerifierRateLimit     time.Duration
+	FaucetRateLimit       time.Duration
+	MaxTotalAllocations   uint
+	MaxAllowancePerWindow big.Int
+	FaucetGrantSize       types.FIL
+	FaucetMaxGrant        types.FIL
+	MinTrustScore         float64
+	blocklist             map[address.Address]bool
+}
+
+// reloadableEnvKeys are the variables a reload takes from ENV_RELOAD_FILE, anything else in the file is ignored
+var reloadableEnvKeys = map[string]bool{
+	"VERIFIER_RATE_LIMIT":      true,
+	"FAUCET_RATE_LIMIT":        true,
+	"MAX_TOTAL_ALLOCATIONS":    true,
+	"MAX_ALLOWANCE_PER_WINDOW": true,
+	"FAUCET_GRANT_SIZE":        true,
+	"FAUCET_MAX_GRANT":         true,
+	"MIN_TRUST_SCORE":          true,
+	"BLOCKED_ADDRESSES":        true,
+}
+
+var (
+	currentSettings atomic.Value
+	// reloadMu serializes reloads, which go through the process environment
+	reloadMu sync.Mutex
+)
+
+func settings() *runtimeSettings {
+	return currentSettings.Load().(*runtimeSettings)
+}
+
+// settingsFromEnv validates the reloadable part of e, so a bad reload is refused rather than half applied
+func settingsFromEnv(e Env) (*runtimeSettings, error) {
+	if e.VerifierRateLimit < 0 || e.FaucetRateLimit < 0 {
+		return nil, errors.New("rate limits can't be negative")
+	}
+	if e.MaxAllowancePerWindow.Int == nil || e.MaxAllowancePerWindow.LessThan(big.Zero()) {
+		return nil, errors.New("MAX_ALLOWANCE_PER_WINDOW can't be negative")
+	}
+	if !e.MaxAllowancePerWindow.IsZero() && !transactionsEnabled() {
+		return nil, errors.New("MAX_ALLOWANCE_PER_WINDOW needs DYNAMODB_TRANSACTIONS_TABLE_NAME")
+	}
+	if big.Int(e.FaucetGrantSize).LessThan(big.Zero()) || big.Int(e.FaucetMaxGrant).LessThan(big.Zero()) {
+		return nil, errors.New("faucet grants can't be negative")
+	}
+	// proportional grants grow with the miner's power, so they always need a ceiling
+	if e.FaucetFirstTimeMode == FaucetGrantModeProportional && big.Int(e.FaucetMaxGrant).Sign() == 0 {
+		return nil, errors.New("FAUCET_FIRST_TIME_MODE=proportional needs FAUCET_MAX_GRANT")
+	}
+	blocklist, err := parseBlockList(e.BlockedAddresses)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing BLOCKED_ADDRESSES")
+	}
+
+	return &runtimeSettings{
+		VerifierRateLimit:     e.VerifierRateLimit,
+		FaucetRateLimit:       e.FaucetRateLimit,
+		MaxTotalAllocations:   e.MaxTotalAllocations,
+		MaxAllowancePerWindow: e.MaxAllowancePerWindow,
+		FaucetGrantSize:       e.FaucetGrantSize,
+		FaucetMaxGrant:        e.FaucetMaxGrant,
+		MinTrustScore:         e.MinTrustScore,
+		blocklist:             blocklist,
+	}, nil
+}
+
+func initSettings() error {
+	s, err := settingsFromEnv(env)
+	if err != nil {
+		return err
+	}
+	currentSettings.Store(s)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reloadSettings(); err != nil {
+				log.Println("error reloading settings:", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// readReloadFile reads the reloadable KEY=VALUE lines of ENV_RELOAD_FILE, blank lines and # comments are skipped
+func readReloadFile() (map[string]string, error) {
+	if len(env.EnvReloadFile) == 0 {
+		return nil, errors.New("ENV_RELOAD_FILE isn't set")
+	}
+	f, err := os.Open(env.EnvReloadFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("%q isn't KEY=VALUE", line)
+		}
+		key := strings.TrimSpace(parts[0])
+		if !reloadableEnvKeys[key] {
+			log.Println("ignoring", key, "on reload, it needs a restart")
+			continue
+		}
+		values[key] = strings.Trim(strings.TrimSpace(parts[1]), `"`)
+	}
+	return values, scanner.Err()
+}
+
+// reloadSettings applies ENV_RELOAD_FILE on top of the environment and swaps in the result. The environment is
+// put back as it was if the new settings don't validate.
+func reloadSettings() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	values, err := readReloadFile()
+	if err != nil {
+		return err
+	}
+
+	previous := map[string]string{}
+	for key, value := range values {
+		previous[key] = os.Getenv(key)
+		os.Setenv(key, value)
+	}
+	restore := func() {
+		for key, value := range previous {
+			os.Setenv(key, value)
+		}
+	}
+
+	var next Env
+	if err := parseEnv(&next); err != nil {
+		restore()
+		return err
+	}
+	s, err := settingsFromEnv(next)
+	if err != nil {
+		restore()
+		return err
+	}
+	currentSettings.Store(s)
+	log.Println("reloaded settings from", env.EnvReloadFile)
+	return nil
+}
+
+// serveReloadSettings is the SIGHUP reload for deployments where signalling the process is awkward
+func serveReloadSettings(c *gin.Context) {
+	if err := reloadSettings(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, AuditAction_ReloadSettings, env.EnvReloadFile, nil)
+	c.JSON(http.StatusOK, settings())
+}
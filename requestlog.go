@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logRequests is gin's request logger, except only LOG_SAMPLE_RATE of successful requests are logged.
+// Failures, a 4xx/5xx status or an error set on the context, are always logged. Client disconnects are
+// sampled like successes unless LOG_CLIENT_DISCONNECTS is set.
+// Each line carries the request ID, so a sampled line can be matched with the response's X-Request-ID.
+// Addresses in the path, query and errors go through redactLog.
+func logRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		if raw := c.Request.URL.RawQuery; raw != "" {
+			path = path + "?" + raw
+		}
+
+		c.Next()
+
+		status := c.Writer.Status()
+		_, hasErr := c.Get("error")
+		failed := status >= 400 || hasErr
+		if clientDisconnected(c) && !env.LogClientDisconnects {
+			failed = false
+		}
+		if !failed && !sampleRequestLog() {
+			return
+		}
+		fmt.Fprintf(gin.DefaultWriter, "[GIN] %v | %3d | %13v | %15s | %-7s %#v | request_id=%s\n%s",
+			start.Format("2006/01/02 - 15:04:05"),
+			status,
+			time.Since(start),
+			c.ClientIP(),
+			c.Request.Method,
+			redactLog(path),
+			requestID(c),
+			redactLog(c.Errors.ByType(gin.ErrorTypePrivate).String()),
+		)
+	}
+}
+
+func sampleRequestLog() bool {
+	return env.LogSampleRate >= 1 || rand.Float64() < env.LogSampleRate
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// retryBudget bounds the retrying done on behalf of one request, however many calls in it retry.
+// It's shared by every context tracedBackground derives for the request.
+type retryBudget struct {
+	mu       sync.Mutex
+	deadline time.Time
+	attempts int
+}
+
+type retryBudgetKey struct{}
+
+// requestRetryBudget returns the request's budget, starting it on first use. Nil means no budget is configured.
+func requestRetryBudget(c *gin.Context) *retryBudget {
+	if env.RetryBudget == 0 && env.RetryBudgetAttempts == 0 {
+		return nil
+	}
+	if budget, ok := c.Get("retryBudget"); ok {
+		return budget.(*retryBudget)
+	}
+
+	budget := &retryBudget{attempts: env.RetryBudgetAttempts}
+	if env.RetryBudget > 0 {
+		budget.deadline = time.Now().Add(env.RetryBudget)
+	}
+	c.Set("retryBudget", budget)
+	return budget
+}
+
+func contextWithRetryBudget(ctx context.Context, budget *retryBudget) context.Context {
+	if budget == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, retryBudgetKey{}, budget)
+}
+
+// spend claims one retry that waits for wait, returning false once the budget can't cover it
+func (b *retryBudget) spend(wait time.Duration) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if env.RetryBudgetAttempts > 0 {
+		if b.attempts <= 0 {
+			return false
+		}
+		b.attempts--
+	}
+	return b.deadline.IsZero() || time.Now().Add(wait).Before(b.deadline)
+}
+
+func retryBudgetFromContext(ctx context.Context) *retryBudget {
+	budget, _ := ctx.Value(retryBudgetKey{}).(*retryBudget)
+	return budget
+}
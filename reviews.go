@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	"github.com/pkg/errors"
+)
+
+type ReviewStatus string
+
+var (
+	ReviewStatus_Pending   ReviewStatus = "Pending"
+	ReviewStatus_Approving ReviewStatus = "Approving"
+	ReviewStatus_Approved  ReviewStatus = "Approved"
+	ReviewStatus_Rejected  ReviewStatus = "Rejected"
+)
+
+// The reviews table is keyed on ID, with global secondary indexes on UserID (hash) and Status (range),
+// and on Status (hash) and CreatedAt (range) for the admin queue
+const (
+	reviewsByUserIndex   = "UserID-Status-index"
+	reviewsByStatusIndex = "Status-CreatedAt-index"
+)
+
+// Review is an allocation held for an admin to approve, see env.ManualReviewThresholdBytes
+type Review struct {
+	ID            string
+	UserID        string
+	Address       string
+	Allowance     string
+	Status        ReviewStatus
+	CreatedAt     time.Time
+	DecidedAt     time.Time `dynamo:",omitempty"`
+	DecidedBy     string    `dynamo:",omitempty"`
+	Reason        string    `dynamo:",omitempty"`
+	Cid           string    `dynamo:",omitempty"`
+	CorrelationID string    `dynamo:",omitempty"`
+}
+
+func requiresManualReview(allowance big.Int) bool {
+	return !env.ManualReviewThresholdBytes.IsZero() && allowance.GreaterThan(env.ManualReviewThresholdBytes)
+}
+
+func createReview(userID, targetAddr string, allowance big.Int, correlationID string) (Review, error) {
+	review := Review{
+		ID:            uuid.New().String(),
+		UserID:        userID,
+		Address:       targetAddr,
+		Allowance:     allowance.String(),
+		Status:        ReviewStatus_Pending,
+		CreatedAt:     time.Now(),
+		CorrelationID: correlationID,
+	}
+	table := dynamoTable(env.DynamodbReviewsTableName)
+	return review, table.Put(review).Run()
+}
+
+func getReviewByID(reviewID string) (Review, error) {
+	table := dynamoTable(env.DynamodbReviewsTableName)
+
+	var review Review
+	err := table.Get("ID", reviewID).One(&review)
+	return review, err
+}
+
+func hasPendingReview(userID string) (bool, error) {
+	if len(env.DynamodbReviewsTableName) == 0 {
+		return false, nil
+	}
+	table := dynamoTable(env.DynamodbReviewsTableName)
+
+	// an approval in progress still holds the user's allocation back
+	for _, status := range []ReviewStatus{ReviewStatus_Pending, ReviewStatus_Approving} {
+		count, err := table.Get("UserID", userID).
+			Index(reviewsByUserIndex).
+			Range("Status", dynamo.Equal, status).
+			Count()
+		if err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func getPendingReviews() ([]Review, error) {
+	table := dynamoTable(env.DynamodbReviewsTableName)
+
+	var reviews []Review
+	err := table.Get("Status", ReviewStatus_Pending).
+		Index(reviewsByStatusIndex).
+		All(&reviews)
+	if err != nil {
+		var empty []Review
+		return empty, err
+	}
+	return reviews, nil
+}
+
+func getReviewsForUser(userID string) ([]Review, error) {
+	table := dynamoTable(env.DynamodbReviewsTableName)
+
+	var reviews []Review
+	err := table.Get("UserID", userID).
+		Index(reviewsByUserIndex).
+		All(&reviews)
+	if err != nil {
+		var empty []Review
+		return empty, err
+	}
+	return reviews, nil
+}
+
+// claimReview moves a review from one status to another, failing if someone else moved it first
+func claimReview(review Review, from, to ReviewStatus) error {
+	table := dynamoTable(env.DynamodbReviewsTableName)
+	return table.Update("ID", review.ID).
+		Set("Status", to).
+		If("$ = ?", "Status", from).
+		Run()
+}
+
+// decideReview moves a review from its current status to its final one, failing if someone else already decided it.
+// Rejections decide a pending review, approvals one claimed as approving. The decision is then sent to the review
+// webhook so the user can be told about it.
+func decideReview(review Review, status ReviewStatus, admin, reason, msgCid string) error {
+	from := review.Status
+
+	review.Status = status
+	review.DecidedAt = time.Now()
+	review.DecidedBy = admin
+	review.Reason = reason
+	review.Cid = msgCid
+
+	table := dynamoTable(env.DynamodbReviewsTableName)
+	update := table.Update("ID", review.ID).
+		Set("Status", review.Status).
+		Set("DecidedAt", review.DecidedAt).
+		Set("DecidedBy", review.DecidedBy).
+		If("$ = ?", "Status", from)
+	if review.Reason != "" {
+		update = update.Set("Reason", review.Reason)
+	}
+	if review.Cid != "" {
+		update = update.Set("Cid", review.Cid)
+	}
+	if err := update.Run(); err != nil {
+		return err
+	}
+
+	go notifyReviewDecision(review)
+	return nil
+}
+
+// notifyReviewDecision tells the review webhook about a decision. We don't hold any contact
+// details for users, so it's up to the receiver to reach them.
+func notifyReviewDecision(review Review) {
+	if len(env.ReviewWebhookURL) == 0 {
+		return
+	}
+
+	type Notification struct {
+		ReviewID      string       `json:"reviewID"`
+		UserID        string       `json:"userID"`
+		Address       string       `json:"address"`
+		Allowance     string       `json:"allowance"`
+		Status        ReviewStatus `json:"status"`
+		Reason        string       `json:"reason,omitempty"`
+		Cid           string       `json:"cid,omitempty"`
+		DecidedAt     time.Time    `json:"decidedAt"`
+		CorrelationID string       `json:"correlationId,omitempty"`
+	}
+	err := postWebhook(env.ReviewWebhookURL, Notification{
+		ReviewID:      review.ID,
+		UserID:        review.UserID,
+		Address:       review.Address,
+		Allowance:     review.Allowance,
+		Status:        review.Status,
+		Reason:        review.Reason,
+		Cid:           review.Cid,
+		DecidedAt:     review.DecidedAt,
+		CorrelationID: review.CorrelationID,
+	})
+	if err != nil {
+		log.Println("error sending review decision webhook:", review.ID, err)
+	}
+}
+
+// bindReviewReason reads the optional {"reason": ...} body sent with a decision
+func bindReviewReason(c *gin.Context) string {
+	type Request struct {
+		Reason string `json:"reason"`
+	}
+
+	var body Request
+	if c.Request.ContentLength == 0 {
+		return ""
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		return ""
+	}
+	return body.Reason
+}
+
+func serveMyReviews(c *gin.Context) {
+	userID, err := getUserIDFromJWT(c)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(env.DynamodbReviewsTableName) == 0 {
+		c.JSON(http.StatusOK, []Review{})
+		return
+	}
+
+	reviews, err := getReviewsForUser(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, reviews)
+}
+
+func serveListPendingReviews(c *gin.Context) {
+	reviews, err := getPendingReviews()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, reviews)
+}
+
+// pendingReviewFromParam loads the review in the :id param and writes an error response if it can't be decided
+func pendingReviewFromParam(c *gin.Context) (Review, bool) {
+	review, err := getReviewByID(c.Param("id"))
+	if err == dynamo.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "review not found"})
+		return Review{}, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return Review{}, false
+	}
+	if review.Status != ReviewStatus_Pending {
+		c.JSON(http.StatusConflict, gin.H{"error": "review was already " + string(review.Status)})
+		return Review{}, false
+	}
+	return review, true
+}
+
+func serveApproveReview(c *gin.Context) {
+	review, ok := pendingReviewFromParam(c)
+	if !ok {
+		return
+	}
+	reason := bindReviewReason(c)
+
+	allowance, err := big.FromString(review.Allowance)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	targetAddr, err := address.NewFromString(review.Address)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Claim the review before submitting anything, so two admins approving at once can't both send the allocation
+	err = claimReview(review, ReviewStatus_Pending, ReviewStatus_Approving)
+	if err != nil && isCondCheckFailed(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "review was already decided"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	review.Status = ReviewStatus_Approving
+
+	// Re-acquire the lock dropped when the request was queued
+	err = lockUser(review.UserID, UserLock_Verifier)
+	if err != nil {
+		if err := claimReview(review, ReviewStatus_Approving, ReviewStatus_Pending); err != nil {
+			log.Println("error returning review to pending:", review.ID, err)
+		}
+		if isLockHeld(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": ErrOperationInProgress.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	// Until the message is submitted nothing will clean the lock up or decide the review, so undo both on any failure
+	submitted := false
+	defer func() {
+		if !submitted {
+			if err := unlockUser(review.UserID, UserLock_Verifier); err != nil {
+				log.Println("error unlocking user after failed approval:", err)
+			}
+			if err := claimReview(review, ReviewStatus_Approving, ReviewStatus_Pending); err != nil {
+				log.Println("error returning review to pending:", review.ID, err)
+			}
+		}
+	}()
+
+	user, err := getUserByID(review.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !claimInflightAddress(targetAddr) {
+		c.JSON(http.StatusConflict, gin.H{"error": ErrAddressInFlight.Error()})
+		return
+	}
+
+	if err := incrementCounter(c); err != nil {
+		releaseInflightAddress(targetAddr)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(tracedBackground(c), 60*time.Minute)
+	defer cancel()
+
+	cid, err := lotusVerifyAccount(ctx, review.Address, allowance)
+	if err != nil {
+		releaseInflightAddress(targetAddr)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errors.Wrap(err, "verifying account").Error()})
+		return
+	}
+
+	submitted = true
+	saveVerification(user, review.Address, cid.String(), allowance, review.CorrelationID)
+
+	if err := decideReview(review, ReviewStatus_Approved, adminName(c), reason, cid.String()); err != nil {
+		log.Println("error saving approved review:", review.ID, err)
+	}
+	recordAudit(c, AuditAction_ApproveReview, review.ID, map[string]string{"address": review.Address, "allowance": allowance.String(), "cid": cid.String(), "reason": reason})
+
+	c.JSON(http.StatusOK, gin.H{"cid": cid.String(), "cidEncoded": encodeCidForResponse(cid)})
+}
+
+func serveRejectReview(c *gin.Context) {
+	review, ok := pendingReviewFromParam(c)
+	if !ok {
+		return
+	}
+
+	reason := bindReviewReason(c)
+	err := decideReview(review, ReviewStatus_Rejected, adminName(c), reason, "")
+	if err != nil && isCondCheckFailed(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "review was already decided"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, AuditAction_RejectReview, review.ID, map[string]string{"address": review.Address, "reason": reason})
+	c.JSON(http.StatusOK, gin.H{"status": ReviewStatus_Rejected})
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,7 +16,6 @@ import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
-	"gopkg.in/robfig/cron.v2"
 )
 
 func registerVerifierHandlers(router *gin.Engine) {
@@ -23,13 +24,40 @@ func registerVerifierHandlers(router *gin.Engine) {
 		slackNotification := "REDIS INIT COUNT FAILED: " + err.Error()
 		sendSlackNotification("https://errors.glif.io/verifier-redis-failed", slackNotification)
 	}
-	router.POST("/verify/:target_addr", serveVerifyAccount)
+	go checkVerifregState()
+	router.POST("/verify/:target_addr", requireWarmedUp, requirePermission(PermissionVerify), serveVerifyAccount)
 	router.PUT("/verify/counter/:pwd", serveResetCounter)
 	router.GET("/verify/counter/:pwd", serveCurrentCount)
-	router.GET("/verifiers", serveListVerifiers)
-	router.GET("/verified-clients", serveListVerifiedClients)
-	router.GET("/account-remaining-bytes/:target_addr", serveCheckAccountRemainingBytes)
-	router.GET("/verifier-remaining-bytes/:target_addr", serveCheckVerifierRemainingBytes)
+	router.GET("/verify/eligibility", requirePermission(PermissionRead), serveVerifyEligibility)
+	router.GET("/verify-quote/:target_addr", requirePermission(PermissionRead), serveVerifyQuote)
+	router.GET("/verify/capacity", chainHeadHeaders, serveVerifyCapacity)
+	router.GET("/verify/receipt/:cid", serveVerifyReceipt)
+	router.GET("/verifiers", chainHeadHeaders, serveListVerifiers)
+	router.GET("/verified-clients", chainHeadHeaders, serveListVerifiedClients)
+	router.GET("/recent-allocations", serveRecentAllocations)
+	router.GET("/account-remaining-bytes/:target_addr", chainHeadHeaders, serveCheckAccountRemainingBytes)
+	router.GET("/verifier-remaining-bytes/:target_addr", chainHeadHeaders, serveCheckVerifierRemainingBytes)
+	router.GET("/verify/projection/:target_addr", serveDataCapProjection)
+
+	router.GET("/me/reviews", requirePermission(PermissionRead), serveMyReviews)
+
+	admin := router.Group("/admin", requireAdmin)
+	admin.GET("/reviews", serveListPendingReviews)
+	admin.POST("/reviews/:id/approve", requireWarmedUp, serveApproveReview)
+	admin.POST("/reviews/:id/reject", serveRejectReview)
+	admin.POST("/users/:id/auto-top-up", serveSetAutoTopUp)
+	admin.POST("/users/:id/tokens", serveMintUserToken)
+	admin.GET("/users/:id/tags", serveUserTags)
+	admin.POST("/users/:id/tags", serveSetUserTags)
+	admin.GET("/allocation-rate", serveAllocationRate)
+	admin.GET("/dashboard", serveAdminDashboard)
+	admin.GET("/duplicate-accounts", serveDuplicateAccounts)
+	admin.POST("/duplicate-accounts/flag", serveFlagDuplicateAccounts)
+	admin.GET("/audit", serveAuditTrail)
+	admin.POST("/reload", serveReloadSettings)
+	admin.GET("/transactions", serveTransactions(true))
+	// walks the whole verified client list at two tipsets, too heavy to leave public
+	admin.GET("/verified-clients/diff", serveVerifiedClientsDiff)
 }
 
 func main() {
@@ -38,58 +66,132 @@ func main() {
 	fmt.Println("Max transaction fee: ", env.MaxFee)
 	fmt.Println("mode: ", env.Mode)
 
-	if err := initBlockListCache(); err != nil { log.Panic(err) }
+	if err := initSettings(); err != nil { log.Panic(err) }
+	if err := initProviderMetadataRequirements(); err != nil { log.Panic(err) }
+	if err := initTrustScoreWeights(); err != nil { log.Panic(err) }
+	if err := initAdminTokens(); err != nil { log.Panic(err) }
+	if err := initAuthPolicies(); err != nil { log.Panic(err) }
+	if err := initCidResponseEncoding(); err != nil { log.Panic(err) }
+	if requiresManualReview(env.MaxAllowanceBytes) && len(env.DynamodbReviewsTableName) == 0 {
+		log.Panic("MANUAL_REVIEW_THRESHOLD_BYTES needs DYNAMODB_REVIEWS_TABLE_NAME")
+	}
+	if env.MinAllocationFraction < 0 || env.MinAllocationFraction > 1 {
+		log.Panic("MIN_ALLOCATION_FRACTION must be between 0 and 1")
+	}
 	if _, err := instantiateWallet(&gin.Context{}); err != nil { log.Panic(err) }
 	
-	router := gin.Default()
+	initTracing()
+	initVerifySubmitQueue()
+
+	router := gin.New()
+	router.Use(logRequests(), gin.Recovery())
+	router.Use(environmentHeader)
+	router.Use(traceRequests())
+	if env.EnableCompression {
+		router.Use(compressResponses())
+	}
+	if env.ResponseEnvelope {
+		router.Use(envelopeResponses())
+	}
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"POST"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		ExposeHeaders:    []string{"Content-Length", "X-Environment"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
+	router.Use(authenticate)
 	router.GET("/", servePong)
 	router.GET("/healthz", servePong)
+	router.GET("/readyz", serveReadiness)
+	router.GET("/health", chainHeadHeaders, serveHealth)
 	router.GET("/ping", servePong)
+	router.GET("/version", serveVersion)
+	router.GET("/capabilities", serveCapabilities)
+	router.GET("/metrics", serveMetrics())
 	router.POST("/oauth/:provider", serveOauth, handleError("/oauth"))
-	c := cron.New()
+	router.POST("/auth/introspect", requireService, serveIntrospectJWT)
+	router.GET("/me/transactions", requirePermission(PermissionRead), serveTransactions(false))
 	if env.Mode == FaucetMode {
-		fmt.Println("Faucet grant size: ", env.FaucetGrantSize)
+		fmt.Println("Faucet grant size: ", settings().FaucetGrantSize)
 		fmt.Println("Faucet min GH account age days: ", env.FaucetMinAccountAgeDays)
 		fmt.Println("Imported faucet: ", FaucetAddr.String())
-		router.POST("/faucet/:target_addr", serveFaucet, handleError("/faucet"))
-		c.AddFunc("@hourly", reconcileFaucetMessages)
+		router.POST("/faucet/:target_addr", requireWarmedUp, requirePermission(PermissionFaucet), serveFaucet, handleError("/faucet"))
+		router.GET("/faucet/receipt/:cid", serveFaucetReceipt)
+		router.Group("/admin", requireAdmin).GET("/faucet/stats", serveFaucetStats)
+		router.GET("/miner/:addr", chainHeadHeaders, serveMinerInfo)
+		if err := monitors.AddFunc("reconcile-faucet", reconcileSchedule(), reconcileFaucetMessages); err != nil {
+			log.Panic(err)
+		}
 	} else if env.Mode == VerifierMode {
 		fmt.Println("Verifier min GH account age days: ", env.VerifierMinAccountAgeDays)
-		fmt.Println("Verifier rate limit: ", env.VerifierRateLimit)
+		fmt.Println("Verifier rate limit: ", settings().VerifierRateLimit)
 		fmt.Println("Verifier grant size: ", env.MaxAllowanceBytes)
 		fmt.Println("Imported verifier: ", VerifierAddr.String())
-		fmt.Println("Max allocations: ", env.MaxTotalAllocations)
+		fmt.Println("Max allocations: ", settings().MaxTotalAllocations)
 
 		registerVerifierHandlers(router)
-		c.AddFunc("@hourly", reconcileVerifierMessages)
+		if err := monitors.AddFunc("reconcile-verifier", reconcileSchedule(), reconcileVerifierMessages); err != nil {
+			log.Panic(err)
+		}
+		if topUpsEnabled() {
+			if err := monitors.AddFunc("top-up", env.TopUpSchedule, runTopUps); err != nil {
+				log.Panic(err)
+			}
+		}
+		if dataCapSnapshotsEnabled() {
+			if err := monitors.AddFunc("datacap-snapshot", env.DataCapSnapshotSchedule, snapshotDataCaps); err != nil {
+				log.Panic(err)
+			}
+		}
 	} else {
-		fmt.Println("Faucet grant size: ", env.FaucetGrantSize)
+		fmt.Println("Faucet grant size: ", settings().FaucetGrantSize)
 		fmt.Println("Faucet min GH account age: ", env.FaucetMinAccountAgeDays)
 		fmt.Println("Verifier min GH account age: ", env.VerifierMinAccountAgeDays)
-		fmt.Println("Verifier rate limit: ", env.VerifierRateLimit)
+		fmt.Println("Verifier rate limit: ", settings().VerifierRateLimit)
 		fmt.Println("Verifier grant size: ", env.MaxAllowanceBytes)
-		fmt.Println("Max allocations: ", env.MaxTotalAllocations)
+		fmt.Println("Max allocations: ", settings().MaxTotalAllocations)
 		fmt.Println("Imported faucet: ", FaucetAddr.String())
 		fmt.Println("Imported verifier: ", VerifierAddr.String())
-		router.POST("/faucet/:target_addr", serveFaucet, handleError("/faucet"))
+		router.POST("/faucet/:target_addr", requireWarmedUp, requirePermission(PermissionFaucet), serveFaucet, handleError("/faucet"))
+		router.GET("/faucet/receipt/:cid", serveFaucetReceipt)
+		router.Group("/admin", requireAdmin).GET("/faucet/stats", serveFaucetStats)
+		router.GET("/miner/:addr", chainHeadHeaders, serveMinerInfo)
 		registerVerifierHandlers(router)
-		c.AddFunc("@hourly", reconcileFaucetMessages)
-		c.AddFunc("@hourly", reconcileVerifierMessages)
+		if err := monitors.AddFunc("reconcile-faucet", reconcileSchedule(), reconcileFaucetMessages); err != nil {
+			log.Panic(err)
+		}
+		if err := monitors.AddFunc("reconcile-verifier", reconcileSchedule(), reconcileVerifierMessages); err != nil {
+			log.Panic(err)
+		}
+		if topUpsEnabled() {
+			if err := monitors.AddFunc("top-up", env.TopUpSchedule, runTopUps); err != nil {
+				log.Panic(err)
+			}
+		}
+		if dataCapSnapshotsEnabled() {
+			if err := monitors.AddFunc("datacap-snapshot", env.DataCapSnapshotSchedule, snapshotDataCaps); err != nil {
+				log.Panic(err)
+			}
+		}
 	}
 
-	c.Start()
-	defer func() {
-		c.Stop()
-	}()
-	router.Run(":" + env.Port)
+	if env.MaxClockSkew > 0 {
+		go checkClockSkew()
+		if err := monitors.AddFunc("clock-skew", "@every 10m", checkClockSkew); err != nil {
+			log.Panic(err)
+		}
+	}
+	if env.LockTimeout > 0 || env.LockHardTimeout > 0 {
+		if err := monitors.AddFunc("release-stale-locks", "@every 10m", releaseStaleLocks); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	monitors.Start(backgroundCtx)
+	go warmUp()
+	runServer(router)
 }
 
 var (
@@ -102,6 +204,38 @@ var (
 	ErrUserLocked           = errors.New("Our servers are processing your last transaction. Come back tomorrow.")
 	ErrAddressBlocked       = errors.New("This address or Miner ID has reached its maximum usage of the faucet.")
 	ErrCounterReached       = errors.New("This notary has run out of data cap for today! Come back tomorrow.")
+	ErrReviewPending        = errors.New("Your last request is waiting for review. You'll be able to request again once it's decided.")
+	ErrLoginBusy            = errors.New("Login is temporarily busy. Please try again in a moment.")
+	ErrOperationInProgress  = errors.New("An operation for this account is already in progress. Please wait for it to complete.")
+	ErrInsufficientSignals  = errors.New("Your linked accounts don't meet this notary's requirements.")
+	ErrEmailNotAllowed      = errors.New("This notary requires a verified email address from an approved organization.")
+	ErrTrustScoreTooLow     = errors.New("Your linked accounts don't have a high enough trust score for this notary.")
+	ErrVerifierOutOfDataCap = errors.New("This notary has run out of data cap. Please try again later.")
+	ErrFaucetOutOfFunds     = errors.New("The faucet doesn't have enough FIL to send this grant. Please try again later.")
+	ErrMpoolGasTooLow       = errors.New("The network rejected this transaction's gas price. Please try again.")
+	ErrMpoolDuplicateNonce  = errors.New("Another transaction is being processed. Please try again in a few minutes.")
+	ErrWindowCapReached     = errors.New("You've reached the maximum data cap for this period. Please try again later.")
+	ErrGasEstimationFailed  = errors.New("Unable to estimate gas for this transaction. Please try again later.")
+	ErrAddressInFlight      = errors.New("A verification for this Filecoin address is already being processed. Please wait for it to complete.")
+	ErrVerifyQueueFull      = errors.New("This notary is handling a lot of requests right now. Please try again shortly.")
+	ErrNodeBusy             = errors.New("The Filecoin node is busy. Please try again in a few minutes.")
+	ErrAddressCapReached    = errors.New("This address has received the maximum number of faucet grants.")
+	ErrMinerPowerTooLow     = errors.New("This miner doesn't have enough raw byte power to use the faucet.")
+	ErrTargetIsVerifier     = errors.New("This Filecoin address is a notary. Please try again with a client address.")
+	ErrAddressNotOnChain    = errors.New("This Filecoin address doesn't exist on chain yet.")
+	ErrFaucetTooRecently    = errors.New("You've used the faucet too recently. Please try again later.")
+	ErrAllocationTooSmall   = errors.New("This Filecoin address already has nearly the maximum data cap this notary grants.")
+	ErrInvalidRequestBody   = errors.New("The request is missing or has invalid fields.")
+	ErrNoLinkedAccounts     = errors.New("Please link a GitHub account before continuing.")
+	ErrAllowanceImplausible = errors.New("Something went wrong calculating your allocation. Please contact the notary.")
+	ErrMissingPermission    = errors.New("This token isn't allowed to do that.")
+	ErrUserBlocked          = errors.New("This account isn't eligible for this notary. Please contact the notary.")
+	ErrTargetBalanceTooLow  = errors.New("This Filecoin address doesn't hold enough FIL for this notary. Please fund it and try again.")
+	ErrTooManyAddresses     = errors.New("You've verified the maximum number of Filecoin addresses for this notary.")
+	ErrAddressChangeTooSoon = errors.New("You changed your verified Filecoin address too recently. Please try again later or use your previous address.")
+	ErrInvalidAuditCursor   = errors.New("after isn't a cursor from a previous page")
+	ErrAccountClaimed       = errors.New("This account is already linked to another user. Please contact the notary.")
+	ErrWarmingUp            = errors.New("The server is warming up. Please try again in a few seconds.")
 )
 
 type UserLock string
@@ -109,6 +243,8 @@ type UserLock string
 var (
 	UserLock_Verifier UserLock = "Verifier"
 	UserLock_Faucet   UserLock = "Faucet"
+	// UserLock_User is held alongside the operation lock when env.LockScope is per-user
+	UserLock_User     UserLock = "User"
 )
 
 func setError(c *gin.Context, code int, err error) {
@@ -121,12 +257,21 @@ func handleError(route string) gin.HandlerFunc {
 		err, hasErr := c.Get("error")
 		code, hasCode := c.Get("code")
 		if hasErr {
-			if hasCode {
+			if fields, hasFields := c.Get("fields"); hasFields {
+				c.JSON(code.(int), gin.H{"error": ErrInvalidRequestBody.Error(), "fields": fields})
+			} else if hasCode {
 				c.JSON(code.(int), gin.H{"error": err.(error).Error()})
 			} else {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.(error).Error()})
 			}
-			log.Printf("%v error: %+v", route, err)
+			if (isCanceled(err.(error)) || clientDisconnected(c)) && !env.LogClientDisconnects {
+				return
+			}
+			if errors.Cause(err.(error)) == context.DeadlineExceeded {
+				log.Printf("%v timed out: %v", route, redactLog(fmt.Sprintf("%+v", err)))
+				return
+			}
+			log.Printf("%v error: %v", route, redactLog(fmt.Sprintf("%+v", err)))
 		}
 	}
 }
@@ -150,46 +295,75 @@ func serveOauth(c *gin.Context) {
 
 	var body Request
 	if err := c.ShouldBindJSON(&body); err != nil {
-		setError(c, http.StatusBadRequest, errors.Wrap(err, "binding request JSON"))
+		setBindingError(c, err)
 		return
 	}
 
+	release, ok := acquireProviderSlot(providerName)
+	if !ok {
+		oauthExchangeRejected.Add(providerName, 1)
+		setUnavailable(c, ErrLoginBusy, loginBusyRetryAfter)
+		return
+	}
+	exchangeStart := time.Now()
+
 	// Exchange the `code` for an `access_token`
 	token, err := OAuthExchangeCodeForToken(provider, body.Code, body.State)
 	if err != nil {
+		release()
+		oauthExchangeFailures.Add(providerName, 1)
 		setError(c, http.StatusInternalServerError, errors.Wrap(err, "exchanging code for token"))
 		return
 	}
 
 	// Fetch the user's profile
+	fetchStart := time.Now()
 	accountData, err := provider.FetchAccountData(token)
+	release()
+	observeHistogram(oauthFetchLatency, providerName, oauthFetchLatencyBuckets, fetchStart)
+	observeLatency(oauthExchangeCount, oauthExchangeLatencyMs, providerName, exchangeStart)
 	if err != nil {
+		oauthExchangeFailures.Add(providerName, 1)
 		setError(c, http.StatusInternalServerError, errors.Wrap(err, "fetching account data"))
 		return
 	}
 
 	// Update user record in Dynamo
-	user, err := getUserWithProviderUniqueID(providerName, accountData.UniqueID)
+	user, created, err := getUserWithProviderUniqueID(providerName, accountData.UniqueID)
 	if err != nil {
 		setError(c, http.StatusInternalServerError, errors.Wrap(err, "fetching DynamoDB user"))
 		return
 	}
 
+	accountData.ProviderUniqueID = namespacedUniqueID(providerName, accountData.UniqueID)
 	user.Accounts[providerName] = accountData
 
-	err = saveUser(user)
+	if err := claimProviderAccount(accountData.ProviderUniqueID, user.ID); err == ErrAccountClaimed {
+		setError(c, http.StatusConflict, err)
+		return
+	} else if err != nil {
+		setError(c, http.StatusInternalServerError, errors.Wrap(err, "claiming provider account"))
+		return
+	}
+
+	if created {
+		user, err = createUser(user)
+	} else {
+		err = saveUser(user)
+	}
 	if err != nil {
 		setError(c, http.StatusInternalServerError, errors.Wrap(err, "saving DynamoDB user"))
 		return
 	}
+	oauthLogins.Add(providerName, 1)
+	if created {
+		oauthNewUsers.Add(providerName, 1)
+	} else {
+		oauthExistingUsers.Add(providerName, 1)
+	}
 
-	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userID": user.ID,
-		"nbf":    time.Date(2015, 10, 10, 12, 0, 0, 0, time.UTC).Unix(),
-	})
-
-	// Sign and get the complete encoded token as a string using the secret
-	jwtTokenString, err := jwtToken.SignedString([]byte(env.JWTSecret))
+	// Login tokens carry every permission
+	jwtTokenString, err := signUserJWT(user.ID, nil)
 	if err != nil {
 		setError(c, http.StatusInternalServerError, errors.Wrap(err, "generating JWT"))
 		return
@@ -209,138 +383,205 @@ func serveVerifyAccount(c *gin.Context) {
 		return
 	}
 
-	user, err := getUserByID(userID)
+	correlationID, err := bindCorrelationID(c)
 	if err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": ErrStaleJWT.Error()})
+		c.JSON(http.StatusBadRequest, bindingErrorBody(err))
 		return
 	}
 
-	if len(user.Accounts) == 0 {
+	user, err := getUserByID(userID)
+	if err != nil {
 		c.JSON(http.StatusForbidden, gin.H{"error": ErrStaleJWT.Error()})
 		return
 	}
 
-	if user.Locked_Verifier {
-		c.JSON(http.StatusForbidden, gin.H{"error": ErrUserLocked.Error()})
+	// otherwise the age check would fail with a misleading "too new"
+	if len(user.Accounts) == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": ErrNoLinkedAccounts.Error(), "code": "NO_LINKED_ACCOUNTS", "failedCheck": "linked_accounts"})
 		return
 	}
 
 	targetAddrStr := c.Param("target_addr")
 
-	// Ensure that the user hasn't used this address before
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	ctx, cancel := context.WithTimeout(tracedBackground(c), 2*time.Minute)
 	defer cancel()
 
-	// Ensure that the user's account is old enough
-	minAccountAge := time.Duration(env.VerifierMinAccountAgeDays) * 24 * time.Hour
-	// No account less than MinAccountAge is allowed any FIL
-	if !user.HasAccountOlderThan(minAccountAge) {
-		slackNotification := "Requester's ID:" + user.ID + " Requester's FIL address: " + targetAddrStr + "\nRequester's GH Handle: " + user.Accounts["github"].Username + "\nRequester's Account age: " + user.Accounts["github"].CreatedAt.String() + "\n----------"
-		sendSlackNotification("https://errors.glif.io/verifier-account-too-young", slackNotification)
-		c.JSON(http.StatusForbidden, gin.H{"error": ErrUserTooNew.Error()})
+	plan, refusal := checkVerifyRequest(ctx, c, user, targetAddrStr, verifyCheckSubmit)
+	if refusal != nil {
+		c.JSON(refusal.Status, refusal.Body)
 		return
 	}
+	targetAddr, owed, allowance, partial := plan.TargetAddr, plan.Owed, plan.Allowance, plan.Partial
 
-	// Ensure that the user hasn't asked for more allocation too recently
-	if user.MostRecentAllocation.Add(env.VerifierRateLimit).After(time.Now()) {
-		slackNotification := "Requester's ID:" + user.ID + "Requester's FIL address: " + targetAddrStr + "\nRequester's GH Handle: " + user.Accounts["github"].Username + "\nRequester's Most recent allocation: " + user.MostRecentAllocation.String() + "\n----------"
-		sendSlackNotification("https://errors.glif.io/verifier-reallocation-too-soon", slackNotification)
-		c.JSON(http.StatusForbidden, gin.H{"error": ErrAllocatedTooRecently.Error()})
-		return
-	}
-
-	// Lock the user for the duration of this operation until cron job cleans it up
+	// Lock the user for the duration of this operation until cron job cleans it up. Every refusal that doesn't
+	// need the lock comes before it, the ones after have to unlock before returning.
 	err = lockUser(userID, UserLock_Verifier)
-	if err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": ErrUserLocked.Error()})
+	if err != nil && isLockHeld(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": ErrOperationInProgress.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	unlock := func(reason string) {
+		if err := unlockUser(userID, UserLock_Verifier); err != nil {
+			log.Println("error unlocking user after "+reason+":", err)
+		}
+	}
 
 	user, err = getUserByID(userID)
 	if err != nil {
+		unlock("a failed user read")
 		c.JSON(http.StatusForbidden, gin.H{"error": ErrStaleJWT.Error()})
 		return
 	}
 
-	reachedCount, err := reachedCounter(c)
-	if reachedCount {
-		slackNotification := "VERIFIER COUNTER REACHED: " + fmt.Sprint(env.MaxTotalAllocations)
-		sendSlackNotification("https://errors.glif.io/verifier-counter-reached", slackNotification)
-		c.JSON(http.StatusLocked, gin.H{"error": ErrCounterReached.Error()})
-		return
-	}
-
-	if err != nil {
-		slackNotification := "VERIFIER COUNTER CALCULATION FAILED: " + fmt.Sprint(env.MaxTotalAllocations) + err.Error()
-		sendSlackNotification("https://errors.glif.io/verifier-counter-reached", slackNotification)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": ErrCounterReached.Error()})
+	// Large allocations wait for an admin, the lock is dropped and re-acquired on approval
+	if requiresManualReview(allowance) {
+		review, err := createReview(user.ID, targetAddrStr, allowance, correlationID)
+		if err != nil {
+			unlock("a failed review")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := unlockUser(userID, UserLock_Verifier); err != nil {
+			log.Println("error unlocking user queued for review:", err)
+		}
+		c.JSON(http.StatusAccepted, gin.H{"status": "queued for review", "reviewID": review.ID, "correlationId": correlationID})
 		return
 	}
 
-	dataCap, err := lotusCheckVerifierRemainingBytes(c, VerifierAddr.String())
-	if err != nil {
-		slackNotification := "LOTUS CHECK VERIFIER BYTES FAILED" + err.Error() + "\n----------"
-		sendSlackNotification("https://errors.glif.io/verifier-tx-failed", slackNotification)
-		c.JSON(http.StatusLocked, gin.H{"error": ErrCounterReached.Error()})
+	// Claim the address until the cron job sees the message resolve, so no other user can target it concurrently
+	if !claimInflightAddress(targetAddr) {
+		unlock("a target already in flight")
+		c.JSON(http.StatusConflict, gin.H{"error": ErrAddressInFlight.Error(), "failedCheck": "address_in_flight"})
 		return
 	}
-	fiftyDataCaps := types.BigMul(env.MaxAllowanceBytes, types.NewInt(50))
 
-	if dataCap.LessThanEqual(fiftyDataCaps) {
-		slackNotification := "LOW DATA CAP: " + dataCap.String()
-		sendSlackNotification("https://errors.glif.io/verifier-low-data-cap", slackNotification)
-	}
-
-	targetAddr, err := address.NewFromString(targetAddrStr)
+	// Wait our turn to submit, rather than piling onto a congested node
+	releaseSubmitSlot, _, err := acquireVerifySubmitSlot(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		releaseInflightAddress(targetAddr)
+		if err := unlockUser(userID, UserLock_Verifier); err != nil {
+			log.Println("error unlocking user turned away by the submit queue:", err)
+		}
+		// a full queue and a wait that timed out both mean the node is congested
+		respondUnavailable(c, err, env.VerifyQueueRetryAfter)
 		return
 	}
+	defer releaseSubmitSlot()
 
-	if isAddressBlocked(targetAddr) {
-		c.JSON(http.StatusForbidden, gin.H{"error": ErrAddressBlocked.Error()})
+	// the wait for a slot can be long, don't submit for a client that gave up on it
+	if clientDisconnected(c) {
+		releaseInflightAddress(targetAddr)
+		if err := unlockUser(userID, UserLock_Verifier); err != nil {
+			log.Println("error unlocking user that disconnected:", err)
+		}
+		abortDisconnected(c)
 		return
 	}
 
 	// Allocate the bytes
 	err = incrementCounter(c)
 	if err != nil {
+		releaseInflightAddress(targetAddr)
+		unlock("a failed counter increment")
 		slackNotification := "REDIS INCREMENT COUNT FAILED: " + err.Error()
 		sendSlackNotification("https://errors.glif.io/verifier-redis-failed", slackNotification)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	ctx, cancel = context.WithTimeout(context.Background(), 60*time.Minute)
+	ctx, cancel = context.WithTimeout(tracedBackground(c), 60*time.Minute)
 	defer cancel()
 
-	cid, err := lotusVerifyAccount(ctx, targetAddrStr, env.MaxAllowanceBytes)
+	cid, err := lotusVerifyAccount(ctx, targetAddrStr, allowance)
 	if err != nil {
+		releaseInflightAddress(targetAddr)
+		if errors.Cause(err) == ErrGasEstimationFailed {
+			log.Println("verify gas estimation failed:", redactLog(err.Error()))
+			unlock("a failed gas estimation")
+			c.JSON(http.StatusBadGateway, gin.H{"error": ErrGasEstimationFailed.Error()})
+			return
+		}
+		if errors.Cause(err) == ErrAllowanceImplausible {
+			log.Println("refused implausible allowance:", redactLog(err.Error()))
+			sendSlackNotification("https://errors.glif.io/verifier-tx-failed", "IMPLAUSIBLE ALLOWANCE REFUSED: "+err.Error())
+			if err := unlockUser(userID, UserLock_Verifier); err != nil {
+				log.Println("error unlocking user after refusing allowance:", err)
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": ErrAllowanceImplausible.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	user.MostRecentDataCapCid = cid.String()
+	saveVerification(user, targetAddrStr, cid.String(), allowance, correlationID)
+
+	// Respond to the HTTP request
+	type Response struct {
+		Cid            string    `json:"cid"`
+		CidEncoded     string    `json:"cidEncoded,omitempty"`
+		Verifier       string    `json:"verifier"`
+		Allocated      string    `json:"allocated"`
+		Partial        bool      `json:"partial"`
+		Shortfall      string    `json:"shortfall,omitempty"`
+		NextEligibleAt time.Time `json:"nextEligibleAt"`
+		CorrelationID  string    `json:"correlationId,omitempty"`
+		*AddressForms
+	}
+	resp := Response{
+		Cid:        cid.String(),
+		CidEncoded: encodeCidForResponse(cid),
+		Verifier:   VerifierAddr.String(),
+		Allocated:  allowance.String(),
+		Partial:    partial,
+		// the cooldown runs from when the message lands, so this is the earliest it can be
+		NextEligibleAt: time.Now().Add(settings().VerifierRateLimit),
+		CorrelationID:  correlationID,
+	}
+	if partial {
+		resp.Shortfall = types.BigSub(owed, allowance).String()
+	}
+	// the message is already sent, so a failed lookup only leaves the forms off
+	if wantAddressForms(c) {
+		if forms, err := lotusAddressForms(ctx, targetAddr); err == nil {
+			resp.AddressForms = forms
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// saveVerification records a submitted verify message on the user and in the transaction records
+func saveVerification(user User, targetAddrStr, msgCid string, allowance types.BigInt, correlationID string) {
+	user.MostRecentDataCapCid = msgCid
+	recordVerifiedAddress(&user, targetAddrStr)
 	user.MostRecentVerifiedAddress = targetAddrStr
+	user.MostRecentVerifierAddress = VerifierAddr.String()
+
+	recordTransaction(Transaction{
+		Cid:           msgCid,
+		Type:          TransactionType_Verify,
+		UserID:        user.ID,
+		From:          VerifierAddr.String(),
+		Address:       targetAddrStr,
+		Amount:        allowance.String(),
+		CorrelationID: correlationID,
+	})
 
-	err = saveUser(user)
+	err := saveUser(user)
 	if err != nil {
 		// TODO what to do here?
 		log.Println("error saving user:", err)
 	}
-
-	// Respond to the HTTP request
-	type Response struct {
-		Cid string `json:"cid"`
-	}
-	c.JSON(http.StatusOK, Response{Cid: cid.String()})
 }
 
 func serveListVerifiers(c *gin.Context) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(tracedBackground(c), 30*time.Second)
 	defer cancel()
 
-	verifiers, err := lotusListVerifiers(ctx)
+	verifiers, err := lotusListVerifiers(contextWithMaxStateAge(ctx, c))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -349,10 +590,15 @@ func serveListVerifiers(c *gin.Context) {
 }
 
 func serveListVerifiedClients(c *gin.Context) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if c.Query("format") == "ndjson" {
+		serveStreamVerifiedClients(c)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(tracedBackground(c), 30*time.Second)
 	defer cancel()
 
-	verifiedClients, err := lotusListVerifiedClients(ctx)
+	verifiedClients, err := lotusListVerifiedClients(contextWithMaxStateAge(ctx, c))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -360,10 +606,86 @@ func serveListVerifiedClients(c *gin.Context) {
 	c.JSON(http.StatusOK, verifiedClients)
 }
 
+const (
+	defaultRecentAllocations = 10
+	maxRecentAllocations     = 100
+)
+
+func serveRecentAllocations(c *gin.Context) {
+	if !transactionsEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "transaction records are not enabled"})
+		return
+	}
+
+	limit := int64(defaultRecentAllocations)
+	if limitStr := c.Query("limit"); limitStr != "" {
+		n, err := strconv.ParseInt(limitStr, 10, 64)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+	if limit > maxRecentAllocations {
+		limit = maxRecentAllocations
+	}
+
+	txs, err := getRecentTransactions(TransactionType_Verify, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// only public chain data, no user IDs
+	type Allocation struct {
+		Cid         string    `json:"cid"`
+		Address     string    `json:"address"`
+		Amount      string    `json:"amount"`
+		ConfirmedAt time.Time `json:"confirmedAt"`
+	}
+	allocations := []Allocation{}
+	for _, tx := range txs {
+		allocations = append(allocations, Allocation{
+			Cid:         tx.Cid,
+			Address:     tx.Address,
+			Amount:      tx.Amount,
+			ConfirmedAt: tx.ConfirmedAt,
+		})
+	}
+	c.JSON(http.StatusOK, allocations)
+}
+
+// serveStreamVerifiedClients writes one JSON object per line as the HAMT is walked,
+// so neither side has to hold the whole list in memory
+func serveStreamVerifiedClients(c *gin.Context) {
+	// rooted in the request context so a client disconnect aborts the walk
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Minute)
+	defer cancel()
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+
+	enc := json.NewEncoder(c.Writer)
+	err := lotusWalkVerifiedClients(ctx, func(client addrAndDataCap) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := enc.Encode(client); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err != nil && !(isCanceled(err) && !env.LogClientDisconnects) {
+		// the status is already written, all we can do is stop and log
+		log.Println("error streaming verified clients:", err)
+	}
+}
+
 func serveCheckAccountRemainingBytes(c *gin.Context) {
 	targetAddr := c.Param("target_addr")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(tracedBackground(c), 30*time.Second)
 	defer cancel()
 
 	dcap, err := lotusCheckAccountRemainingBytes(ctx, targetAddr)
@@ -374,14 +696,27 @@ func serveCheckAccountRemainingBytes(c *gin.Context) {
 
 	type Response struct {
 		RemainingBytes       string    `json:"remainingBytes"`
+		RemainingBytesHuman  string    `json:"remainingBytesHuman"`
+		RemainingBytesHex    string    `json:"remainingBytesHex"`
+		*AddressForms
+	}
+	resp := Response{dcap.String(), formatBytesHuman(dcap), formatBytesHex(dcap), nil}
+	if wantAddressForms(c) {
+		// lotusCheckAccountRemainingBytes already parsed it
+		addr, _ := address.NewFromString(targetAddr)
+		resp.AddressForms, err = lotusAddressForms(ctx, addr)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 	}
-	c.JSON(http.StatusOK, Response{dcap.String()})
+	c.JSON(http.StatusOK, resp)
 }
 
 func serveCheckVerifierRemainingBytes(c *gin.Context) {
 	targetAddr := c.Param("target_addr")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(tracedBackground(c), 30*time.Second)
 	defer cancel()
 
 	dcap, err := lotusCheckVerifierRemainingBytes(ctx, targetAddr)
@@ -392,6 +727,37 @@ func serveCheckVerifierRemainingBytes(c *gin.Context) {
 	c.JSON(http.StatusOK, dcap)
 }
 
+// serveVerifyCapacity tells the frontend up front whether a verify request could currently be covered
+func serveVerifyCapacity(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(tracedBackground(c), 30*time.Second)
+	defer cancel()
+
+	dataCap, err := lotusCheckVerifierRemainingBytes(ctx, VerifierAddr.String())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	available := verifierAvailableBytes(dataCap)
+	maxAllocation := env.MaxAllowanceBytes
+	if available.LessThan(maxAllocation) {
+		maxAllocation = available
+	}
+
+	type Response struct {
+		CanCover            bool   `json:"canCover"`
+		RemainingBytes      string `json:"remainingBytes"`
+		AvailableBytes      string `json:"availableBytes"`
+		MaxSingleAllocation string `json:"maxSingleAllocation"`
+	}
+	c.JSON(http.StatusOK, Response{
+		CanCover:            !available.IsZero() && (env.AllowPartialAllocation || !available.LessThan(env.MaxAllowanceBytes)),
+		RemainingBytes:      dataCap.String(),
+		AvailableBytes:      available.String(),
+		MaxSingleAllocation: maxAllocation.String(),
+	})
+}
+
 func serveFaucet(c *gin.Context) {
 	userID, err := getUserIDFromJWT(c)
 	if err != nil {
@@ -399,25 +765,58 @@ func serveFaucet(c *gin.Context) {
 		return
 	}
 
+	correlationID, err := bindCorrelationID(c)
+	if err != nil {
+		setBindingError(c, err)
+		return
+	}
+
+	// A repeat of a request that's in flight or just finished, usually a double-click
+	dedupKey := faucetDedupKey(userID, c.Param("target_addr"))
+	if claimed, previous := claimFaucetDedup(dedupKey); !claimed && previous != nil {
+		c.JSON(http.StatusOK, previous)
+		return
+	} else if !claimed {
+		rejectFaucet(c, http.StatusConflict, FaucetRejectInProgress, ErrOperationInProgress, nil)
+		return
+	}
+	completed := false
+	defer func() {
+		if !completed {
+			releaseFaucetDedup(dedupKey)
+		}
+	}()
+
 	user, err := getUserByID(userID)
 	if err != nil {
 		c.JSON(http.StatusForbidden, gin.H{"error": ErrStaleJWT.Error()})
 		return
 	}
 
+	// otherwise the age check would fail with a misleading "too new"
 	if len(user.Accounts) == 0 {
-		c.JSON(http.StatusForbidden, gin.H{"error": ErrStaleJWT.Error()})
+		rejectFaucet(c, http.StatusForbidden, FaucetRejectNoLinkedAccounts, ErrNoLinkedAccounts, gin.H{"code": "NO_LINKED_ACCOUNTS"})
 		return
 	}
 
-	if user.Locked_Faucet {
-		c.JSON(http.StatusForbidden, gin.H{"error": ErrUserLocked.Error()})
+	if user.HasTag(UserTag_Blocked) {
+		rejectFaucet(c, http.StatusForbidden, FaucetRejectUserBlocked, ErrUserBlocked, nil)
+		return
+	}
+
+	if user.IsLocked(UserLock_Faucet) {
+		rejectFaucet(c, http.StatusForbidden, FaucetRejectInProgress, ErrUserLocked, nil)
 		return
 	}
 
 	// This can get deleted, along with the `ReceivedFaucetGrant` key in dynamo if the faucet policy changes away from 1 time use only
-	if user.ReceivedFaucetGrant {
-		c.JSON(http.StatusForbidden, gin.H{"error": ErrFaucetRepeatAttempt.Error()})
+	if env.FaucetOneTimeOnly && user.ReceivedFaucetGrant {
+		rejectFaucet(c, http.StatusForbidden, FaucetRejectAlreadyClaimed, ErrFaucetRepeatAttempt, nil)
+		return
+	}
+
+	if nextEligible := user.MostRecentFaucetGrant.Add(settings().FaucetRateLimit); nextEligible.After(time.Now()) {
+		rejectFaucet(c, http.StatusForbidden, FaucetRejectRateLimited, ErrFaucetTooRecently, gin.H{"nextEligibleAt": nextEligible})
 		return
 	}
 
@@ -425,56 +824,173 @@ func serveFaucet(c *gin.Context) {
 
 	minAccountAge := time.Duration(env.FaucetMinAccountAgeDays) * 24 * time.Hour
 	// No account less than MinAccountAge is allowed any FIL
-	if !user.HasAccountOlderThan(minAccountAge) {
+	if !user.MeetsMinAccountAge(minAccountAge) {
 		slackNotification := "Requester's FIL address: " + targetAddrStr + "\nRequester's GH Handle: " + user.Accounts["github"].Username + "\nRequester's Account age: " + user.Accounts["github"].CreatedAt.String() + "\n----------"
 		sendSlackNotification("https://errors.glif.io/faucet-account-too-young", slackNotification)
-		c.JSON(http.StatusForbidden, gin.H{"error": ErrUserTooNew.Error()})
+		rejectFaucet(c, http.StatusForbidden, FaucetRejectTooNew, ErrUserTooNew, gin.H{"minAccountAgeDays": env.FaucetMinAccountAgeDays})
 		return
 	}
 
-	// Lock the user for the duration of this operation
-	err = lockUser(userID, UserLock_Faucet)
+	ctx, cancel := context.WithTimeout(tracedBackground(c), 2*time.Minute)
+	defer cancel()
+
+	targetAddr, err := address.NewFromString(targetAddrStr)
 	if err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": ErrUserLocked.Error()})
+		rejectFaucet(c, http.StatusBadRequest, FaucetRejectInvalidAddress, err, nil)
 		return
 	}
 
-	user, err = getUserByID(userID)
-	if err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": ErrStaleJWT.Error()})
+	if isAddressBlocked(targetAddr) {
+		rejectFaucet(c, http.StatusForbidden, FaucetRejectAddressBlocked, ErrAddressBlocked, nil)
 		return
 	}
 
+	api, closer, err := lotusGetFullNodeAPI(ctx)
+	if err != nil {
+		setError(c, http.StatusInternalServerError, errors.Wrap(err, "getting full node API"))
+		return
+	}
+	defer closer()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-	defer cancel()
+	// everything else read before sending depends on whether the target is a miner
+	isMiner, err := lotusIsMiner(ctx, api, targetAddr)
+	if err != nil {
+		setError(c, http.StatusInternalServerError, errors.Wrap(err, "looking up target actor"))
+		return
+	}
 
-	targetAddr, err := address.NewFromString(targetAddrStr)
+	// The rest are independent of each other. Miners may be funded at their worker or owner instead of the
+	// address they asked with, and the ID address the funds land in is reported when the recipient is on chain.
+	power := types.NewInt(0)
+	var recipientAddr, resolvedAddr address.Address
+	var faucetBalance types.BigInt
+	err = runReads(ctx,
+		func(ctx context.Context) (err error) {
+			if isMiner && needsMinerPower() {
+				power, err = lotusGetMinerPower(ctx, api, targetAddr)
+			}
+			return errors.Wrap(err, "getting miner power")
+		},
+		func(ctx context.Context) (err error) {
+			recipientAddr, err = lotusFaucetRecipient(ctx, api, targetAddr, isMiner)
+			if err != nil {
+				return errors.Wrap(err, "resolving faucet recipient")
+			}
+			resolvedAddr, err = lotusLookupIDAddress(ctx, api, recipientAddr)
+			return errors.Wrap(err, "resolving recipient ID address")
+		},
+		func(ctx context.Context) (err error) {
+			faucetBalance, err = lotusWalletBalance(ctx, FaucetAddr)
+			return errors.Wrap(err, "getting faucet balance")
+		},
+	)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		setError(c, http.StatusInternalServerError, err)
 		return
 	}
 
-	if isAddressBlocked(targetAddr) {
-		c.JSON(http.StatusForbidden, gin.H{"error": ErrAddressBlocked.Error()})
+	// Don't fund miners with next to no power, they're most likely not real
+	if isMiner && !env.FaucetMinMinerPower.IsZero() {
+		if power.LessThan(env.FaucetMinMinerPower) {
+			rejectFaucet(c, http.StatusForbidden, FaucetRejectMinerPower,
+				errors.New(ErrMinerPowerTooLow.Error()+" The minimum is "+formatBytesHuman(env.FaucetMinMinerPower)+"."),
+				gin.H{"power": power.String(), "minPower": env.FaucetMinMinerPower.String()})
+			return
+		}
+	}
+
+	// Lock the user for the duration of this operation. As in serveVerifyAccount the refusals that don't need
+	// the lock come before it, the ones after have to unlock before returning.
+	err = lockUser(userID, UserLock_Faucet)
+	if err != nil && isLockHeld(err) {
+		rejectFaucet(c, http.StatusConflict, FaucetRejectInProgress, ErrOperationInProgress, nil)
+		return
+	} else if err != nil {
+		setError(c, http.StatusInternalServerError, errors.Wrap(err, "locking user"))
 		return
 	}
+	unlock := func(reason string) {
+		if err := unlockUser(userID, UserLock_Faucet); err != nil {
+			log.Println("error unlocking user after "+reason+":", err)
+		}
+	}
 
-	api, closer, err := lotusGetFullNodeAPI(ctx)
+	user, err = getUserByID(userID)
 	if err != nil {
-		setError(c, http.StatusInternalServerError, errors.Wrap(err, "getting full node API"))
+		unlock("a failed user read")
+		c.JSON(http.StatusForbidden, gin.H{"error": ErrStaleJWT.Error()})
+		return
+	}
+
+	firstTime := !user.ReceivedFaucetGrant && user.MostRecentFaucetGrant.IsZero()
+	grant, clamped, grantReason := faucetGrantAmount(firstTime, isMiner, power)
+
+	// the mpool would turn the message away anyway, without the address grant claimed and released on the way
+	if faucetBalance.LessThan(types.BigInt(grant)) {
+		unlock("a faucet out of funds")
+		setUnavailable(c, ErrFaucetOutOfFunds, faucetRetryAfter(ErrFaucetOutOfFunds))
+		return
+	}
+
+	// nothing has been claimed or sent yet, a client that's gone by now doesn't get a grant it never sees
+	if clientDisconnected(c) {
+		if err := unlockUser(userID, UserLock_Faucet); err != nil {
+			log.Println("error unlocking user that disconnected:", err)
+		}
+		abortDisconnected(c)
+		return
+	}
+
+	// Count the grant against the address itself, so it can't be farmed through several users
+	capAddr := recipientAddr.String()
+	if resolvedAddr != address.Undef {
+		capAddr = resolvedAddr.String()
+	}
+	if err := claimAddressGrant(capAddr); err != nil && errors.Cause(err) == ErrAddressCapReached {
+		unlock("an address at its grant cap")
+		rejectFaucet(c, http.StatusForbidden, FaucetRejectAddressCap, ErrAddressCapReached, nil)
+		return
+	} else if err != nil {
+		unlock("a failed address grant claim")
+		setError(c, http.StatusInternalServerError, errors.Wrap(err, "claiming address grant"))
 		return
 	}
-	defer closer()
 
-	cid, err := lotusSendFIL(context.TODO(), api, FaucetAddr, targetAddr, env.FaucetGrantSize)
+	cid, err := lotusSendFIL(ctx, api, FaucetAddr, recipientAddr, grant)
 	if err != nil {
-		setError(c, http.StatusInternalServerError, errors.Wrapf(err, "sending %v from %v to %v", env.FaucetGrantSize, FaucetAddr, targetAddr))
+		if err := releaseAddressGrant(capAddr); err != nil {
+			log.Println("error releasing address grant:", logAddr(capAddr), err)
+		}
+	}
+	if err != nil && errors.Cause(err) == ErrGasEstimationFailed {
+		log.Println("faucet gas estimation failed:", redactLog(err.Error()))
+		unlock("a failed gas estimation")
+		setError(c, http.StatusBadGateway, ErrGasEstimationFailed)
+		return
+	} else if rejection := errors.Cause(err); err != nil && (rejection == ErrFaucetOutOfFunds || rejection == ErrMpoolGasTooLow || rejection == ErrMpoolDuplicateNonce) {
+		log.Println("faucet message rejected by mpool:", redactLog(err.Error()))
+		unlock("an mpool rejection")
+		setUnavailable(c, rejection, faucetRetryAfter(rejection))
+		return
+	} else if err != nil {
+		setError(c, http.StatusInternalServerError, errors.Wrapf(err, "sending %v from %v to %v", grant, FaucetAddr, recipientAddr))
 		return
 	}
 
 	user.MostRecentFaucetGrantCid = cid.String()
 	user.MostRecentFaucetAddress = targetAddrStr
+	user.MostRecentFaucetGrant = time.Now()
+
+	recordTransaction(Transaction{
+		Cid:             cid.String(),
+		Type:            TransactionType_Faucet,
+		UserID:          user.ID,
+		From:            FaucetAddr.String(),
+		Address:         targetAddr.String(),
+		ResolvedAddress: addressStringOrEmpty(resolvedAddr),
+		Amount:          grant.String(),
+		CorrelationID:   correlationID,
+	})
 
 	err = saveUser(user)
 	if err != nil {
@@ -483,45 +999,95 @@ func serveFaucet(c *gin.Context) {
 
 	// Respond to the HTTP request
 	type Response struct {
-		Cid     string `json:"cid"`
-		Sent    string `json:"sent"`
-		Address string `json:"toAddress"`
+		Cid             string     `json:"cid"`
+		CidEncoded      string     `json:"cidEncoded,omitempty"`
+		Sent            string     `json:"sent"`
+		Address         string     `json:"toAddress"`
+		ResolvedAddress string     `json:"resolvedAddress,omitempty"`
+		NextEligibleAt  *time.Time `json:"nextEligibleAt,omitempty"`
+		Clamped         bool       `json:"clamped"`
+
+		// GrantReason says which rule sized the grant, GrantReasonMessage explains it to the user
+		GrantReason        FaucetGrantReason `json:"grantReason"`
+		GrantReasonMessage string            `json:"grantReasonMessage"`
+		CorrelationID      string            `json:"correlationId,omitempty"`
 	}
-	c.JSON(http.StatusOK, Response{
-		Cid:     cid.String(),
-		Sent:    env.FaucetGrantSize.String(),
-		Address: targetAddr.String(),
-	})
+	resp := Response{
+		Cid:                cid.String(),
+		CidEncoded:         encodeCidForResponse(cid),
+		Sent:               grant.String(),
+		Address:            targetAddr.String(),
+		ResolvedAddress:    addressStringOrEmpty(resolvedAddr),
+		Clamped:            clamped,
+		GrantReason:        grantReason,
+		GrantReasonMessage: grantReason.Message(),
+		CorrelationID:      correlationID,
+	}
+	// one time grants are never eligible again
+	if !env.FaucetOneTimeOnly {
+		nextEligible := user.MostRecentFaucetGrant.Add(settings().FaucetRateLimit)
+		resp.NextEligibleAt = &nextEligible
+	}
+	completeFaucetDedup(dedupKey, resp)
+	completed = true
+	c.JSON(http.StatusOK, resp)
 }
 
+// getUserIDFromJWT returns the user authenticate already found, or reads the JWT itself on routes it skipped
 func getUserIDFromJWT(c *gin.Context) (string, error) {
+	if userID := c.GetString("userID"); len(userID) > 0 {
+		return userID, nil
+	}
+	return userIDFromAuthorizationHeader(c)
+}
+
+func userIDFromAuthorizationHeader(c *gin.Context) (string, error) {
+	userID, _, err := parseAuthorizationHeader(c)
+	return userID, err
+}
+
+func parseAuthorizationHeader(c *gin.Context) (string, jwt.MapClaims, error) {
 	authHeader := c.GetHeader("Authorization")
 	if !strings.HasPrefix(authHeader, "Bearer ") {
-		return "", errors.New("bad Authorization header")
+		return "", nil, errors.New("bad Authorization header")
 	}
 
 	jwtToken := strings.TrimSpace(authHeader[len("Bearer "):])
+	return parseUserJWT(jwtToken)
+}
 
-	token, err := jwt.Parse(jwtToken, func(token *jwt.Token) (interface{}, error) {
+// parseUserJWT validates a JWT we issued, returning the user ID and the rest of its claims
+// The time claims are checked here rather than by jwt-go, with JWT_LEEWAY either side for clock skew.
+func parseUserJWT(jwtToken string) (string, jwt.MapClaims, error) {
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.Parse(jwtToken, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
 		}
 		return []byte(env.JWTSecret), nil
 	})
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok || !token.Valid {
-		return "", err
+		return "", nil, errors.New("invalid JWT")
+	}
+	leeway := int64(env.JWTLeeway.Seconds())
+	now := time.Now().Unix()
+	if !claims.VerifyExpiresAt(now-leeway, false) {
+		return "", nil, errors.New("JWT is expired")
+	}
+	if !claims.VerifyNotBefore(now+leeway, false) {
+		return "", nil, errors.New("JWT is not valid yet")
 	}
 
 	userID, ok := claims["userID"].(string)
 	if !ok {
-		return "", err
+		return "", nil, errors.New("JWT has no userID")
 	}
-	return userID, nil
+	return userID, claims, nil
 }
 
 func serveResetCounter(c *gin.Context) {
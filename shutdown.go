@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+)
+
+// backgroundCtx is the root of all work that shouldn't be tied to a single request, like message submission
+// and the cron jobs. It's cancelled once the server has shut down, so nothing outlives the process cleanly.
+var backgroundCtx, cancelBackground = context.WithCancel(context.Background())
+
+// runServer serves until SIGINT or SIGTERM, then gives in-flight requests up to SHUTDOWN_TIMEOUT to finish
+// before cancelling the background work they started
+func runServer(router *gin.Engine) {
+	srv := &http.Server{Addr: ":" + env.Port, Handler: router}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Panic(err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Println("shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), env.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println("error shutting down server:", err)
+	}
+	cancelBackground()
+	shutdownTracing(ctx)
+}
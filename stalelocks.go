@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// lockMessageCid is the message a lock is waiting on. It's written to the user with the lock held,
+// so while the lock is taken it's either the new message or, if sending never got that far, the previous one.
+func lockMessageCid(user User, lock UserLock) string {
+	switch lock {
+	case UserLock_Verifier:
+		return user.MostRecentDataCapCid
+	case UserLock_Faucet:
+		return user.MostRecentFaucetGrantCid
+	}
+	return ""
+}
+
+func lockedAt(user User, lock UserLock) time.Time {
+	switch lock {
+	case UserLock_Verifier:
+		return user.LockedAt_Verifier
+	case UserLock_Faucet:
+		return user.LockedAt_Faucet
+	}
+	return time.Time{}
+}
+
+// lockMessageResolved reports whether nothing is left to wait for on a lock's message. A message that has landed
+// but whose transaction is still pending is left to the reconcile job, which records the outcome before unlocking.
+func lockMessageResolved(ctx context.Context, user User, lock UserLock) (bool, error) {
+	msgCid, err := cid.Decode(lockMessageCid(user, lock))
+	if err != nil {
+		// nothing was ever sent
+		return true, nil
+	}
+
+	mLookup, err := lotusSearchMessageResult(ctx, msgCid)
+	if err != nil {
+		return false, err
+	} else if mLookup == nil {
+		return false, nil
+	}
+
+	if transactionsEnabled() {
+		if tx, err := getTransaction(msgCid.String()); err == nil && tx.Status == TransactionStatus_Pending {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// releaseStaleLocks frees locks left behind by requests that failed after locking. Past LOCK_TIMEOUT a lock is only
+// released once StateSearchMsg shows its message resolved, so a slow confirmation isn't cut short.
+// Past LOCK_HARD_TIMEOUT it's released regardless. Locks taken before LockedAt_* existed are left alone.
+func releaseStaleLocks() {
+	ctx, span := startSpan(backgroundCtx, "releaseStaleLocks", trace.SpanKindInternal)
+	defer span.End()
+
+	for _, lock := range []UserLock{UserLock_Verifier, UserLock_Faucet} {
+		users, err := getLockedUsers(lock)
+		if err != nil {
+			sendSlackMessage(err.Error() + "error getting locked users")
+			return
+		}
+
+		for _, user := range users {
+			since := lockedAt(user, lock)
+			if since.IsZero() {
+				continue
+			}
+			age := time.Since(since)
+
+			switch {
+			case env.LockHardTimeout > 0 && age > env.LockHardTimeout:
+				sendSlackMessage("FORCE RELEASING STALE " + string(lock) + " LOCK: " + user.ID)
+			case env.LockTimeout > 0 && age > env.LockTimeout:
+				resolved, err := lockMessageResolved(ctx, user, lock)
+				if err != nil {
+					sendSlackMessage(err.Error())
+					continue
+				} else if !resolved {
+					continue
+				}
+			default:
+				continue
+			}
+
+			if lock == UserLock_Verifier {
+				releaseInflightAddressString(user.MostRecentVerifiedAddress)
+			}
+			clearUserLock(&user, lock)
+			if err := saveUser(user); err != nil {
+				sendSlackMessage(err.Error())
+			}
+		}
+	}
+}
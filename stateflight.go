@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin"
+	"github.com/gin-gonic/gin"
+	"github.com/ipfs/go-cid"
+	"golang.org/x/sync/singleflight"
+)
+
+// stateReads collapses concurrent identical walks of the verified registry into one
+var stateReads singleflight.Group
+
+type cachedStateRead struct {
+	result []addrAndDataCap
+	at     time.Time
+}
+
+// stateCache holds the last result of each operation for STATE_CACHE_TTL
+var (
+	stateCache   = make(map[string]cachedStateRead)
+	stateCacheMu sync.Mutex
+)
+
+type maxStateAgeKey struct{}
+
+// requestMaxStateAge reads how stale a caller will accept state, from ?maxAge=<seconds> or Cache-Control: max-age.
+// -1 means the caller didn't say, and the cache TTL applies.
+func requestMaxStateAge(c *gin.Context) time.Duration {
+	raw := c.Query("maxAge")
+	if len(raw) == 0 {
+		for _, directive := range strings.Split(c.GetHeader("Cache-Control"), ",") {
+			if directive = strings.TrimSpace(directive); strings.HasPrefix(directive, "max-age=") {
+				raw = strings.TrimPrefix(directive, "max-age=")
+			}
+		}
+	}
+	seconds, err := strconv.Atoi(raw)
+	if err != nil || seconds < 0 {
+		return -1
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// contextWithMaxStateAge passes the request's freshness requirement down to sharedStateRead
+func contextWithMaxStateAge(ctx context.Context, c *gin.Context) context.Context {
+	return context.WithValue(ctx, maxStateAgeKey{}, requestMaxStateAge(c))
+}
+
+func cachedStateReadFor(ctx context.Context, operation string) ([]addrAndDataCap, bool) {
+	if env.StateCacheTTL == 0 {
+		return nil, false
+	}
+	stateCacheMu.Lock()
+	cached, ok := stateCache[operation]
+	stateCacheMu.Unlock()
+
+	age := time.Since(cached.at)
+	if !ok || age > env.StateCacheTTL {
+		return nil, false
+	}
+	if maxAge, set := ctx.Value(maxStateAgeKey{}).(time.Duration); set && maxAge >= 0 && age > maxAge {
+		return nil, false
+	}
+	return cached.result, true
+}
+
+func lotusVerifregHead(ctx context.Context) (cid.Cid, error) {
+	api, closer, err := lotusGetFullNodeAPI(ctx)
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer closer()
+
+	var act *types.Actor
+	err = traceRPC(ctx, "StateGetActor", func(ctx context.Context) (err error) {
+		act, err = api.StateGetActor(ctx, builtin.VerifiedRegistryActorAddr, types.EmptyTSK)
+		return err
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+	return act.Head, nil
+}
+
+// sharedStateRead runs load once for all the callers asking for the same operation at the same verified registry
+// head, so a burst of requests costs one HAMT walk. Callers share the result and must not modify it.
+// The walk runs with the first caller's context, so its cancellation fails everyone waiting on it.
+// With STATE_CACHE_TTL results are cached too, unless the caller asked for state fresher than the cached copy.
+func sharedStateRead(ctx context.Context, operation string, load func(ctx context.Context) ([]addrAndDataCap, error)) ([]addrAndDataCap, error) {
+	if cached, ok := cachedStateReadFor(ctx, operation); ok {
+		return cached, nil
+	}
+
+	var resp []addrAndDataCap
+	if env.SingleFlightStateReads {
+		head, err := lotusVerifregHead(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err, _ := stateReads.Do(operation+"/"+head.String(), func() (interface{}, error) {
+			return load(ctx)
+		})
+		if err != nil {
+			return nil, err
+		}
+		resp = result.([]addrAndDataCap)
+	} else {
+		var err error
+		if resp, err = load(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if env.StateCacheTTL > 0 {
+		stateCacheMu.Lock()
+		stateCache[operation] = cachedStateRead{result: resp, at: time.Now()}
+		stateCacheMu.Unlock()
+	}
+	return resp, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/robfig/cron.v2"
+)
+
+// The supervisor runs the background monitors (message reconciliation, top-ups, ...) on their cron schedules.
+// Only cron.v2's parser is used, the supervisor runs the schedules itself so every monitor stops when
+// backgroundCtx is cancelled on shutdown and /health can report on each. Like cron.v2's runner, a run that
+// panics is logged and the monitor carries on at its next tick. Monitors named in DISABLED_MONITORS are never started.
+
+type monitorStatus struct {
+	Name        string    `json:"name"`
+	Schedule    string    `json:"schedule"`
+	Running     bool      `json:"running"`
+	LastRun     time.Time `json:"lastRun,omitempty"`
+	Panics      int       `json:"panics"`
+	LastPanic   string    `json:"lastPanic,omitempty"`
+	LastPanicAt time.Time `json:"lastPanicAt,omitempty"`
+}
+
+type monitor struct {
+	schedule cron.Schedule
+	fn       func()
+	status   monitorStatus
+}
+
+type supervisor struct {
+	mu       sync.Mutex
+	monitors []*monitor
+}
+
+var monitors = &supervisor{}
+
+func monitorDisabled(name string) bool {
+	for _, disabled := range strings.Split(env.DisabledMonitors, ",") {
+		if strings.TrimSpace(disabled) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// AddFunc registers fn to run on the cron spec under name
+func (s *supervisor) AddFunc(name, spec string, fn func()) error {
+	if monitorDisabled(name) {
+		fmt.Println("Monitor disabled: ", name)
+		return nil
+	}
+	schedule, err := cron.Parse(spec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.monitors = append(s.monitors, &monitor{schedule: schedule, fn: fn, status: monitorStatus{Name: name, Schedule: spec}})
+	return nil
+}
+
+func (s *supervisor) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.monitors {
+		fmt.Println("Starting monitor: ", m.status.Name)
+		go s.supervise(ctx, m)
+	}
+}
+
+// supervise calls the monitor on its schedule until ctx is done
+func (s *supervisor) supervise(ctx context.Context, m *monitor) {
+	s.setRunning(m, true)
+	defer s.setRunning(m, false)
+
+	for {
+		timer := time.NewTimer(time.Until(m.schedule.Next(time.Now())))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.run(m)
+	}
+}
+
+// run calls the monitor once, recovering a panic so one bad run doesn't stop the monitor for good
+func (s *supervisor) run(m *monitor) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("monitor", m.status.Name, "panicked:", r)
+			s.mu.Lock()
+			m.status.Panics++
+			m.status.LastPanic = fmt.Sprint(r)
+			m.status.LastPanicAt = time.Now()
+			s.mu.Unlock()
+		}
+	}()
+
+	m.fn()
+	s.mu.Lock()
+	m.status.LastRun = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *supervisor) setRunning(m *monitor, running bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.status.Running = running
+}
+
+// Statuses is reported by /health
+func (s *supervisor) Statuses() []monitorStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := []monitorStatus{}
+	for _, m := range s.monitors {
+		statuses = append(statuses, m.status)
+	}
+	return statuses
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Users with AutoTopUp set are checked on TOP_UP_SCHEDULE and topped back up to MaxAllowanceBytes once their
+// most recently verified address drops below TOP_UP_THRESHOLD_BYTES. Every cap a manual request is held
+// to still applies, the scheduler just skips a user until the next run when one of them says no.
+
+func topUpsEnabled() bool {
+	return len(env.TopUpSchedule) > 0
+}
+
+func getAutoTopUpUsers() ([]User, error) {
+	table := dynamoTable(env.DynamodbTableName)
+	var users []User
+	err := table.Scan().
+		Filter("AutoTopUp = ?", true).
+		All(&users)
+	if err != nil {
+		var empty []User
+		return empty, err
+	}
+	return users, nil
+}
+
+func runTopUps() {
+	ctx, span := startSpan(backgroundCtx, "runTopUps", trace.SpanKindInternal)
+	defer span.End()
+
+	users, err := getAutoTopUpUsers()
+	if err != nil {
+		sendSlackMessage(err.Error() + "error getting auto top-up users")
+		return
+	}
+
+	for _, user := range users {
+		cid, allowance, err := topUpUser(ctx, user)
+		if err != nil {
+			log.Println("top-up skipped for user", user.ID+":", redactLog(err.Error()))
+			continue
+		} else if allowance.IsZero() {
+			continue
+		}
+		log.Println("top-up allocated", allowance, "to", logAddr(user.MostRecentVerifiedAddress), "for user", user.ID, "in", cid)
+	}
+}
+
+// topUpUser submits a top-up for the user if one is due, returning a zero allowance when it isn't
+func topUpUser(ctx context.Context, user User) (string, types.BigInt, error) {
+	none := types.NewInt(0)
+
+	targetAddrStr := user.MostRecentVerifiedAddress
+	if len(targetAddrStr) == 0 {
+		return "", none, errors.New("no verified address to top up")
+	}
+	if user.HasTag(UserTag_Blocked) {
+		return "", none, ErrUserBlocked
+	}
+	if user.IsLocked(UserLock_Verifier) {
+		return "", none, ErrUserLocked
+	}
+	lastAllocated, err := lastAllocation(ctx, user, targetAddrStr)
+	if err != nil {
+		return "", none, err
+	}
+	if lastAllocated.Add(settings().VerifierRateLimit).After(time.Now()) {
+		return "", none, nil
+	}
+	targetAddr, err := address.NewFromString(targetAddrStr)
+	if err != nil {
+		return "", none, err
+	}
+	if isAddressBlocked(targetAddr) {
+		return "", none, ErrAddressBlocked
+	}
+
+	clientRemaining, err := lotusCheckAccountRemainingBytes(ctx, targetAddrStr)
+	if err != nil {
+		return "", none, err
+	}
+	if clientRemaining.GreaterThanEqual(env.TopUpThresholdBytes) {
+		return "", none, nil
+	}
+	allowance := types.BigSub(env.MaxAllowanceBytes, clientRemaining)
+	if allowance.LessThanEqual(none) || allowance.LessThan(minAllocationBytes()) {
+		return "", none, nil
+	}
+
+	dataCap, err := lotusCheckVerifierRemainingBytes(ctx, VerifierAddr.String())
+	if err != nil {
+		return "", none, err
+	}
+	if available := verifierAvailableBytes(dataCap); available.LessThan(allowance) {
+		if !env.AllowPartialAllocation || available.IsZero() {
+			return "", none, ErrVerifierOutOfDataCap
+		}
+		allowance = available
+	}
+	if !settings().MaxAllowancePerWindow.IsZero() {
+		used, err := windowAllowanceUsed(user.ID)
+		if err != nil {
+			return "", none, err
+		}
+		windowRemaining := types.BigSub(settings().MaxAllowancePerWindow, used)
+		if windowRemaining.LessThan(allowance) {
+			if !env.AllowPartialAllocation || windowRemaining.LessThanEqual(none) {
+				return "", none, ErrWindowCapReached
+			}
+			allowance = windowRemaining
+		}
+	}
+	if requiresManualReview(allowance) {
+		return "", none, errors.New("top-up needs manual review")
+	}
+
+	if reached, err := reachedCounter(ctx); reached || err != nil {
+		return "", none, ErrCounterReached
+	}
+
+	if err := lockUser(user.ID, UserLock_Verifier); err != nil {
+		return "", none, errors.Wrap(err, "locking user")
+	}
+	if !claimInflightAddress(targetAddr) {
+		unlockUser(user.ID, UserLock_Verifier)
+		return "", none, ErrAddressInFlight
+	}
+	if err := incrementCounter(ctx); err != nil {
+		releaseInflightAddress(targetAddr)
+		unlockUser(user.ID, UserLock_Verifier)
+		return "", none, err
+	}
+
+	cid, err := lotusVerifyAccount(ctx, targetAddrStr, allowance)
+	if err != nil {
+		releaseInflightAddress(targetAddr)
+		unlockUser(user.ID, UserLock_Verifier)
+		return "", none, errors.Wrap(err, "verifying account")
+	}
+
+	// re-read so the lock set above isn't overwritten
+	user, err = getUserByID(user.ID)
+	if err != nil {
+		return cid.String(), allowance, err
+	}
+	saveVerification(user, targetAddrStr, cid.String(), allowance, "")
+	return cid.String(), allowance, nil
+}
+
+// serveSetAutoTopUp opts a user in or out of scheduled top-ups
+func serveSetAutoTopUp(c *gin.Context) {
+	type Request struct {
+		Enabled bool `json:"enabled"`
+	}
+	var body Request
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, bindingErrorBody(err))
+		return
+	}
+
+	table := dynamoTable(env.DynamodbTableName)
+	err := table.Update("ID", c.Param("id")).
+		Set("AutoTopUp", body.Enabled).
+		If("attribute_exists(ID)").
+		Run()
+	if err != nil && isCondCheckFailed(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Println("admin", adminName(c), "set auto top-up for user", c.Param("id"), "to", body.Enabled)
+	recordAudit(c, AuditAction_SetAutoTopUp, c.Param("id"), map[string]string{"enabled": strconv.FormatBool(body.Enabled)})
+	c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "autoTopUp": body.Enabled})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlphttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Spans are exported over OTLP/HTTP to env.OtelEndpoint, and propagated with W3C traceparent and baggage
+// headers, both from callers and on to the lotus node. Everything is a no-op when the endpoint isn't set.
+
+const tracerName = "github.com/openworklabs/oauthserver"
+
+var tracerProvider *sdktrace.TracerProvider
+
+func tracingEnabled() bool {
+	return len(env.OtelEndpoint) > 0
+}
+
+func initTracing() {
+	if !tracingEnabled() {
+		return
+	}
+
+	// the OTLP driver takes the collector's host and path separately
+	endpoint, err := url.Parse(env.OtelEndpoint)
+	if err != nil {
+		log.Panic(errors.Wrap(err, "parsing OTEL_ENDPOINT"))
+	}
+	opts := []otlphttp.Option{otlphttp.WithEndpoint(endpoint.Host)}
+	if endpoint.Scheme != "https" {
+		opts = append(opts, otlphttp.WithInsecure())
+	}
+	if path := strings.TrimSuffix(endpoint.Path, "/"); len(path) > 0 {
+		opts = append(opts, otlphttp.WithTracesURLPath(path+"/v1/traces"))
+	}
+	exporter, err := otlp.NewExporter(backgroundCtx, otlphttp.NewDriver(opts...))
+	if err != nil {
+		log.Panic(errors.Wrap(err, "starting trace exporter"))
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.ServiceNameKey.String("filecoin-verifier"))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	fmt.Println("Exporting traces to: ", env.OtelEndpoint)
+}
+
+// shutdownTracing flushes the spans still waiting in the batcher, once nothing is left to start new ones
+func shutdownTracing(ctx context.Context) {
+	if tracerProvider == nil {
+		return
+	}
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		log.Println("error flushing traces:", err)
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// spanParent returns a context the active span can be read from. gin contexts don't pass Value through
+// to their request, so for those it's the request's context.
+func spanParent(ctx context.Context) context.Context {
+	if gc, ok := ctx.(*gin.Context); ok {
+		if gc.Request == nil {
+			return context.Background()
+		}
+		return gc.Request.Context()
+	}
+	return ctx
+}
+
+// startSpan starts a child of the span in ctx, or a new trace
+func startSpan(ctx context.Context, name string, kind trace.SpanKind) (context.Context, trace.Span) {
+	if _, ok := ctx.(*gin.Context); ok {
+		ctx = trace.ContextWithSpan(ctx, trace.SpanFromContext(spanParent(ctx)))
+	}
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(kind))
+}
+
+// finishSpan records err (if any) on the span and ends it
+func finishSpan(s trace.Span, err error) {
+	if err != nil {
+		s.RecordError(err)
+		s.SetStatus(codes.Error, err.Error())
+	}
+	s.End()
+}
+
+// injectTraceHeaders adds the trace context in ctx to outgoing request headers
+func injectTraceHeaders(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(spanParent(ctx), header)
+}
+
+// traceRequests starts a server span for each request, continuing the caller's trace when it sends a traceparent
+func traceRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !tracingEnabled() {
+			c.Next()
+			return
+		}
+
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), c.Request.Header)
+		ctx, s := startSpan(ctx, c.Request.Method+" "+c.FullPath(), trace.SpanKindServer)
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		s.SetAttributes(semconv.HTTPStatusCodeKey.Int(c.Writer.Status()))
+		if err, hasErr := c.Get("error"); hasErr {
+			finishSpan(s, err.(error))
+			return
+		}
+		finishSpan(s, nil)
+	}
+}
+
+// tracedBackground is derived from backgroundCtx rather than the request, for work that shouldn't be
+// cancelled with the request. It keeps the request's span so the work still shows up in its trace,
+// and the request's retry budget, and is cancelled on shutdown. Callers set their own, usually longer, timeout on top.
+func tracedBackground(c *gin.Context) context.Context {
+	return contextWithRetryBudget(trace.ContextWithSpan(backgroundCtx, trace.SpanFromContext(spanParent(c))), requestRetryBudget(c))
+}
+
+// traceRPC runs a lotus RPC inside a client span
+func traceRPC(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	ctx, s := startSpan(ctx, "lotus."+name, trace.SpanKindClient)
+	err := fn(ctx)
+	finishSpan(s, err)
+	return err
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/gin-gonic/gin"
+	"github.com/guregu/dynamo"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultTransactionsPage = 50
+	maxTransactionsPage     = 200
+)
+
+// transactionFilter narrows getTransactions, empty fields match everything
+type transactionFilter struct {
+	UserID string
+	Status []TransactionStatus
+	Type   []TransactionType
+}
+
+// getTransactions scans for transactions matching filter, a page at a time. after is the Cid a previous
+// page ended on, and the Cid to continue from is returned when there may be more.
+func getTransactions(filter transactionFilter, limit int, after string) ([]Transaction, string, error) {
+	table := dynamoTable(env.DynamodbTransactionsTableName)
+
+	var conditions []string
+	var args []interface{}
+	if len(filter.UserID) > 0 {
+		conditions = append(conditions, "UserID = ?")
+		args = append(args, filter.UserID)
+	}
+	if len(filter.Status) > 0 {
+		conditions = append(conditions, "$ IN ("+placeholders(len(filter.Status))+")")
+		args = append(args, "Status")
+		for _, status := range filter.Status {
+			args = append(args, status)
+		}
+	}
+	if len(filter.Type) > 0 {
+		conditions = append(conditions, "$ IN ("+placeholders(len(filter.Type))+")")
+		args = append(args, "Type")
+		for _, txType := range filter.Type {
+			args = append(args, txType)
+		}
+	}
+
+	scan := table.Scan()
+	if len(conditions) > 0 {
+		scan = scan.Filter(strings.Join(conditions, " AND "), args...)
+	}
+	if len(after) > 0 {
+		scan = scan.StartFrom(dynamo.PagingKey{"Cid": {S: aws.String(after)}})
+	}
+
+	txs := []Transaction{}
+	iter := scan.Iter()
+	var tx Transaction
+	for len(txs) < limit && iter.Next(&tx) {
+		txs = append(txs, tx)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(txs) == limit {
+		next = txs[len(txs)-1].Cid
+	}
+	return txs, next, nil
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// transactionFilterFromQuery reads the comma separated status and type query params, e.g. ?status=failed&type=verify
+func transactionFilterFromQuery(c *gin.Context) (transactionFilter, error) {
+	var filter transactionFilter
+	if statuses := c.Query("status"); len(statuses) > 0 {
+		for _, s := range strings.Split(statuses, ",") {
+			status, ok := map[string]TransactionStatus{
+				"pending":   TransactionStatus_Pending,
+				"confirmed": TransactionStatus_Confirmed,
+				"failed":    TransactionStatus_Failed,
+				"replaced":  TransactionStatus_Replaced,
+			}[strings.ToLower(strings.TrimSpace(s))]
+			if !ok {
+				return filter, errors.Errorf("unknown status %q", s)
+			}
+			filter.Status = append(filter.Status, status)
+		}
+	}
+	if types := c.Query("type"); len(types) > 0 {
+		for _, t := range strings.Split(types, ",") {
+			txType, ok := map[string]TransactionType{
+				"verify": TransactionType_Verify,
+				"faucet": TransactionType_Faucet,
+			}[strings.ToLower(strings.TrimSpace(t))]
+			if !ok {
+				return filter, errors.Errorf("unknown type %q", t)
+			}
+			filter.Type = append(filter.Type, txType)
+		}
+	}
+	return filter, nil
+}
+
+// serveTransactions lists transaction records filtered by the query params, with ?limit and ?after for paging.
+// Behind requireAdmin any user's can be listed with ?userID, otherwise it's only the caller's own.
+func serveTransactions(admin bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !transactionsEnabled() {
+			c.JSON(http.StatusNotFound, gin.H{"error": "transaction records are not enabled"})
+			return
+		}
+
+		filter, err := transactionFilterFromQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if admin {
+			filter.UserID = c.Query("userID")
+		} else {
+			userID, err := getUserIDFromJWT(c)
+			if err != nil {
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				return
+			}
+			filter.UserID = userID
+		}
+
+		limit := defaultTransactionsPage
+		if limitStr := c.Query("limit"); limitStr != "" {
+			n, err := strconv.Atoi(limitStr)
+			if err != nil || n <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+				return
+			}
+			limit = n
+		}
+		if limit > maxTransactionsPage {
+			limit = maxTransactionsPage
+		}
+
+		txs, next, err := getTransactions(filter, limit, c.Query("after"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"transactions": txs, "next": next})
+	}
+}
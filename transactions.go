@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/guregu/dynamo"
+	"github.com/pkg/errors"
+)
+
+type TransactionType string
+
+var (
+	TransactionType_Verify TransactionType = "Verify"
+	TransactionType_Faucet TransactionType = "Faucet"
+)
+
+type TransactionStatus string
+
+var (
+	TransactionStatus_Pending   TransactionStatus = "Pending"
+	TransactionStatus_Confirmed TransactionStatus = "Confirmed"
+	TransactionStatus_Failed    TransactionStatus = "Failed"
+	TransactionStatus_Replaced  TransactionStatus = "Replaced"
+)
+
+// The transactions table is keyed on Cid, with a global secondary index on Type (hash) and ConfirmedAt (range),
+// and one on each of UserID, Address and ResolvedAddress (hash) with CreatedAt (range). ConfirmedAt is only
+// written once a message succeeds, so its index only ever holds confirmed transactions.
+const (
+	transactionsByConfirmedAtIndex     = "Type-ConfirmedAt-index"
+	transactionsByUserIndex            = "UserID-CreatedAt-index"
+	transactionsByAddressIndex         = "Address-CreatedAt-index"
+	transactionsByResolvedAddressIndex = "ResolvedAddress-CreatedAt-index"
+)
+
+type Transaction struct {
+	Cid     string
+	Type    TransactionType
+	Status  TransactionStatus
+	UserID  string
+	From    string
+	Address string
+	// ResolvedAddress is the ID address of Address, if it was on chain when the message was sent
+	ResolvedAddress string `dynamo:",omitempty"`
+	Amount          string
+	CreatedAt       time.Time
+	ConfirmedAt     time.Time `dynamo:",omitempty"`
+	// ReplacedBy is the message that took this one's nonce in the mpool, see reportReplacedMessage
+	ReplacedBy    string `dynamo:",omitempty"`
+	CorrelationID string `dynamo:",omitempty"`
+}
+
+func transactionsEnabled() bool {
+	return len(env.DynamodbTransactionsTableName) > 0
+}
+
+// recordTransaction stores a newly submitted message. Failures are only logged, the message is already on its way.
+func recordTransaction(tx Transaction) {
+	if !transactionsEnabled() {
+		return
+	}
+	tx.Status = TransactionStatus_Pending
+	tx.CreatedAt = time.Now()
+
+	table := dynamoTable(env.DynamodbTransactionsTableName)
+	if err := table.Put(tx).Run(); err != nil {
+		log.Println("error recording transaction:", tx.Cid, err)
+	}
+}
+
+// resolveTransaction marks a transaction as confirmed or failed once its message lands on chain
+func resolveTransaction(cid string, confirmed bool) error {
+	if !transactionsEnabled() {
+		return nil
+	}
+	table := dynamoTable(env.DynamodbTransactionsTableName)
+	update := table.Update("Cid", cid)
+	if confirmed {
+		update = update.
+			Set("Status", TransactionStatus_Confirmed).
+			Set("ConfirmedAt", time.Now())
+	} else {
+		update = update.Set("Status", TransactionStatus_Failed)
+	}
+	return update.Run()
+}
+
+// markTransactionReplaced records that a pending transaction's message was replaced by another with the same nonce
+func markTransactionReplaced(cid, replacedBy string) error {
+	if !transactionsEnabled() {
+		return nil
+	}
+	table := dynamoTable(env.DynamodbTransactionsTableName)
+	return table.Update("Cid", cid).
+		Set("Status", TransactionStatus_Replaced).
+		Set("ReplacedBy", replacedBy).
+		If("$ = ?", "Status", TransactionStatus_Pending).
+		Run()
+}
+
+func getTransaction(cid string) (Transaction, error) {
+	table := dynamoTable(env.DynamodbTransactionsTableName)
+
+	var tx Transaction
+	err := table.Get("Cid", cid).One(&tx)
+	return tx, err
+}
+
+// isTransactionReplaced reports whether the message was replaced, meaning it will never land on chain
+func isTransactionReplaced(cid string) bool {
+	if !transactionsEnabled() {
+		return false
+	}
+	tx, err := getTransaction(cid)
+	if err != nil {
+		return false
+	}
+	return tx.Status == TransactionStatus_Replaced
+}
+
+// getUserTransactionsSince returns a user's transactions of a type created after since, excluding failed and replaced ones
+func getUserTransactionsSince(userID string, txType TransactionType, since time.Time) ([]Transaction, error) {
+	table := dynamoTable(env.DynamodbTransactionsTableName)
+
+	var txs []Transaction
+	err := table.Get("UserID", userID).
+		Index(transactionsByUserIndex).
+		Range("CreatedAt", dynamo.Greater, since).
+		Filter("$ = ? AND $ <> ? AND $ <> ?", "Type", txType, "Status", TransactionStatus_Failed, "Status", TransactionStatus_Replaced).
+		All(&txs)
+	if err != nil {
+		var empty []Transaction
+		return empty, err
+	}
+	return txs, nil
+}
+
+// latestAllocationFromTransactions returns when the user, or anyone targeting one of the addresses, was last
+// allocated DataCap according to the transaction records. Each address is matched against both the address a
+// message was sent to and the ID address it resolved to. Zero if there are none.
+func latestAllocationFromTransactions(userID string, addresses []string) (time.Time, error) {
+	table := dynamoTable(env.DynamodbTransactionsTableName)
+	queries := []*dynamo.Query{table.Get("UserID", userID).Index(transactionsByUserIndex)}
+	for _, addr := range addresses {
+		queries = append(queries,
+			table.Get("Address", addr).Index(transactionsByAddressIndex),
+			table.Get("ResolvedAddress", addr).Index(transactionsByResolvedAddressIndex),
+		)
+	}
+
+	var latest time.Time
+	for _, query := range queries {
+		iter := query.
+			Filter("$ = ? AND $ <> ? AND $ <> ?", "Type", TransactionType_Verify, "Status", TransactionStatus_Failed, "Status", TransactionStatus_Replaced).
+			Iter()
+
+		var tx Transaction
+		for iter.Next(&tx) {
+			// pending transactions count from when they were sent, they'll be confirmed later than that
+			at := tx.CreatedAt
+			if tx.ConfirmedAt.After(at) {
+				at = tx.ConfirmedAt
+			}
+			if at.After(latest) {
+				latest = at
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return latest, nil
+}
+
+// cooldownAddresses returns the forms a target's transactions could have been recorded under, the address as
+// given and, when it's on chain, its ID address, so switching between the f0 and f1 forms doesn't skip the cooldown
+func cooldownAddresses(ctx context.Context, targetAddrStr string) ([]string, error) {
+	addresses := []string{targetAddrStr}
+	targetAddr, err := address.NewFromString(targetAddrStr)
+	if err != nil {
+		// nothing can have been sent to an address that doesn't parse, it's refused later on
+		return addresses, nil
+	}
+
+	canonical, _, err := lotusCanonicalAddress(ctx, targetAddr)
+	if err != nil && errors.Cause(err) == ErrAddressNotOnChain {
+		return addresses, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if canonical.String() != targetAddrStr {
+		addresses = append(addresses, canonical.String())
+	}
+	return addresses, nil
+}
+
+// windowAllowanceUsed sums the DataCap a user was allocated inside the rolling AllowanceWindow
+func windowAllowanceUsed(userID string) (big.Int, error) {
+	txs, err := getUserTransactionsSince(userID, TransactionType_Verify, time.Now().Add(-env.AllowanceWindow))
+	if err != nil {
+		return big.Int{}, err
+	}
+
+	used := big.Zero()
+	for _, tx := range txs {
+		amount, err := big.FromString(tx.Amount)
+		if err != nil {
+			return big.Int{}, err
+		}
+		used = big.Add(used, amount)
+	}
+	return used, nil
+}
+
+// getRecentTransactions returns the most recently confirmed transactions of a type, newest first
+func getRecentTransactions(txType TransactionType, limit int64) ([]Transaction, error) {
+	table := dynamoTable(env.DynamodbTransactionsTableName)
+
+	var txs []Transaction
+	err := table.Get("Type", txType).
+		Index(transactionsByConfirmedAtIndex).
+		Order(dynamo.Descending).
+		Limit(limit).
+		All(&txs)
+	if err != nil {
+		var empty []Transaction
+		return empty, err
+	}
+	return txs, nil
+}
+
+// getTransactionsConfirmedSince returns every transaction of a type confirmed after since, using the ConfirmedAt index
+func getTransactionsConfirmedSince(txType TransactionType, since time.Time) ([]Transaction, error) {
+	table := dynamoTable(env.DynamodbTransactionsTableName)
+
+	var txs []Transaction
+	err := table.Get("Type", txType).
+		Index(transactionsByConfirmedAtIndex).
+		Range("ConfirmedAt", dynamo.Greater, since).
+		All(&txs)
+	if err != nil {
+		var empty []Transaction
+		return empty, err
+	}
+	return txs, nil
+}
+
+// getTransactionsWithStatus scans for transactions in a status created after since, there's no index on Status
+func getTransactionsWithStatus(status TransactionStatus, since time.Time) ([]Transaction, error) {
+	table := dynamoTable(env.DynamodbTransactionsTableName)
+
+	var txs []Transaction
+	err := table.Scan().
+		Filter("$ = ? AND CreatedAt > ?", "Status", status, since).
+		All(&txs)
+	if err != nil {
+		var empty []Transaction
+		return empty, err
+	}
+	return txs, nil
+}
+
+// lastAllocation is what the verify cooldown runs from. The user record's MostRecentAllocation is lost if the
+// record is reset, and doesn't cover the same address being allocated through another account, so with
+// COOLDOWN_FROM_TRANSACTIONS the transaction records are checked too. The most recent of the two wins.
+func lastAllocation(ctx context.Context, user User, targetAddrStr string) (time.Time, error) {
+	latest := user.MostRecentAllocation
+	if !transactionsEnabled() || !env.CooldownFromTransactions {
+		return latest, nil
+	}
+
+	addresses, err := cooldownAddresses(ctx, targetAddrStr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	fromTransactions, err := latestAllocationFromTransactions(user.ID, addresses)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if fromTransactions.After(latest) {
+		latest = fromTransactions
+	}
+	return latest, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const (
+	trustSignalAccountAgeDays  = "account_age_days"
+	trustSignalLinkedProviders = "linked_providers"
+)
+
+var trustScoreWeights = make(map[string]float64)
+
+// parse the trust score weights once at startup so a bad config fails loudly
+func initTrustScoreWeights() error {
+	if len(env.TrustScoreWeights) == 0 {
+		return nil
+	}
+
+	for _, e := range strings.Split(env.TrustScoreWeights, ",") {
+		parts := strings.SplitN(strings.TrimSpace(e), "=", 2)
+		if len(parts) != 2 {
+			return errors.Errorf("parsing TRUST_SCORE_WEIGHTS: expected signal=weight, got '%v'", e)
+		}
+		weight, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return errors.Wrapf(err, "parsing TRUST_SCORE_WEIGHTS '%v'", e)
+		}
+		fmt.Println("Trust score weight " + parts[0] + ": " + parts[1])
+		trustScoreWeights[parts[0]] = weight
+	}
+	return nil
+}
+
+// trustSignal returns the raw, unweighted value of a signal for the user
+func (user User) trustSignal(signal string) float64 {
+	switch signal {
+	case trustSignalAccountAgeDays:
+		var oldest float64
+		for _, account := range user.Accounts {
+			days := time.Now().Sub(account.CreatedAt).Hours() / 24
+			if days > oldest {
+				oldest = days
+			}
+		}
+		return oldest
+	case trustSignalLinkedProviders:
+		return float64(len(user.Accounts))
+	}
+
+	name := strings.SplitN(signal, ".", 2)
+	if len(name) != 2 {
+		return 0
+	}
+	n, _ := user.providerMetadataNumber(name[0], name[1])
+	return n
+}
+
+// userTrustScoreBreakdown returns the weighted contribution of each configured signal
+func userTrustScoreBreakdown(user User) map[string]float64 {
+	breakdown := make(map[string]float64)
+	for signal, weight := range trustScoreWeights {
+		breakdown[signal] = weight * user.trustSignal(signal)
+	}
+	return breakdown
+}
+
+// userTrustScore combines the configured signals into a single anti-sybil score
+func userTrustScore(user User) float64 {
+	var score float64
+	for _, contribution := range userTrustScoreBreakdown(user) {
+		score += contribution
+	}
+	return score
+}
+
+func meetsMinTrustScore(user User) bool {
+	return settings().MinTrustScore <= 0 || userTrustScore(user) >= settings().MinTrustScore
+}
+
+func serveVerifyEligibility(c *gin.Context) {
+	userID, err := getUserIDFromJWT(c)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := getUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": ErrStaleJWT.Error()})
+		return
+	}
+
+	type Response struct {
+		Score     float64            `json:"score"`
+		MinScore  float64            `json:"minScore"`
+		Eligible  bool               `json:"eligible"`
+		Breakdown map[string]float64 `json:"breakdown"`
+	}
+	c.JSON(http.StatusOK, Response{
+		Score:     userTrustScore(user),
+		MinScore:  settings().MinTrustScore,
+		Eligible:  meetsMinTrustScore(user),
+		Breakdown: userTrustScoreBreakdown(user),
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Retry-After values for 503s that aren't configured elsewhere, roughly how long each condition lasts
+const (
+	loginBusyRetryAfter      = 5 * time.Second
+	outOfFundsRetryAfter     = time.Hour
+	gasTooLowRetryAfter      = blockTime
+	duplicateNonceRetryAfter = 2 * time.Minute
+)
+
+// faucetRetryAfter is how long a client should wait after the mpool turns a faucet message away
+func faucetRetryAfter(rejection error) time.Duration {
+	switch errors.Cause(rejection) {
+	case ErrFaucetOutOfFunds:
+		return outOfFundsRetryAfter
+	case ErrMpoolGasTooLow:
+		return gasTooLowRetryAfter
+	default:
+		return duplicateNonceRetryAfter
+	}
+}
+
+func setRetryAfter(c *gin.Context, retryAfter time.Duration) {
+	seconds := int(retryAfter.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", fmt.Sprint(seconds))
+}
+
+// respondUnavailable answers with a 503, always with a Retry-After so clients know when to come back
+func respondUnavailable(c *gin.Context, reason error, retryAfter time.Duration) {
+	setRetryAfter(c, retryAfter)
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": reason.Error()})
+}
+
+// setUnavailable is respondUnavailable for handlers that report errors through setError and handleError
+func setUnavailable(c *gin.Context, reason error, retryAfter time.Duration) {
+	setRetryAfter(c, retryAfter)
+	setError(c, http.StatusServiceUnavailable, reason)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/guregu/dynamo"
+)
+
+// Tags are free-form labels admins attach to a user for program management, e.g. "vip-partner".
+// A few have meaning to the eligibility gates.
+const (
+	// UserTag_Blocked rejects every verify and faucet request from the user
+	UserTag_Blocked = "blocked"
+	// UserTag_Trusted waives the minimum account age
+	UserTag_Trusted = "trusted"
+)
+
+// HasTag reports whether an admin has tagged the user with tag
+func (user User) HasTag(tag string) bool {
+	for _, t := range user.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// MeetsMinAccountAge is HasAccountOlderThan, unless the user is tagged trusted
+func (user User) MeetsMinAccountAge(threshold time.Duration) bool {
+	return user.HasTag(UserTag_Trusted) || user.HasAccountOlderThan(threshold)
+}
+
+// normalizeTags lowercases, trims and dedupes tags so "Blocked " and "blocked" are the same tag
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// tagUser adds tag to the user's tags, keeping any already set
+func tagUser(userID, tag string) error {
+	user, err := getUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.HasTag(tag) {
+		return nil
+	}
+	table := dynamoTable(env.DynamodbTableName)
+	return table.Update("ID", userID).
+		Set("Tags", normalizeTags(append(user.Tags, tag))).
+		Run()
+}
+
+func serveUserTags(c *gin.Context) {
+	user, err := getUserByID(c.Param("id"))
+	if err == dynamo.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": user.ID, "tags": normalizeTags(user.Tags), "note": user.AdminNote})
+}
+
+// serveSetUserTags replaces a user's tags and admin note
+func serveSetUserTags(c *gin.Context) {
+	type Request struct {
+		Tags []string `json:"tags" binding:"dive,max=64"`
+		Note string   `json:"note" binding:"max=1024"`
+	}
+	var body Request
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, bindingErrorBody(err))
+		return
+	}
+	tags := normalizeTags(body.Tags)
+
+	table := dynamoTable(env.DynamodbTableName)
+	err := table.Update("ID", c.Param("id")).
+		Set("Tags", tags).
+		Set("AdminNote", body.Note).
+		If("attribute_exists(ID)").
+		Run()
+	if err != nil && isCondCheckFailed(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Println("admin", adminName(c), "set tags for user", c.Param("id"), "to", tags)
+	recordAudit(c, AuditAction_SetUserTags, c.Param("id"), map[string]string{"tags": strings.Join(tags, ","), "note": body.Note})
+	c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "tags": tags, "note": body.Note})
+}
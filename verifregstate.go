@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+
+	apibstore "github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/types"
+	builtin4 "github.com/filecoin-project/specs-actors/v4/actors/builtin"
+	verifreg4 "github.com/filecoin-project/specs-actors/v4/actors/builtin/verifreg"
+	builtin5 "github.com/filecoin-project/specs-actors/v5/actors/builtin"
+	verifreg5 "github.com/filecoin-project/specs-actors/v5/actors/builtin/verifreg"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+)
+
+// verifregRoots are the parts of the verified registry state the HAMT walks need, whichever actor version it's from
+type verifregRoots struct {
+	Verifiers       cid.Cid
+	VerifiedClients cid.Cid
+}
+
+type verifregSchema struct {
+	code   cid.Cid
+	decode func(ctx context.Context, cst cbor.IpldStore, head cid.Cid) (verifregRoots, error)
+}
+
+// verifregSchemas are the verified registry state types this build can decode, by actor version
+var verifregSchemas = map[int]verifregSchema{
+	4: {builtin4.VerifiedRegistryActorCodeID, func(ctx context.Context, cst cbor.IpldStore, head cid.Cid) (verifregRoots, error) {
+		var st verifreg4.State
+		err := cst.Get(ctx, head, &st)
+		return verifregRoots{st.Verifiers, st.VerifiedClients}, err
+	}},
+	5: {builtin5.VerifiedRegistryActorCodeID, func(ctx context.Context, cst cbor.IpldStore, head cid.Cid) (verifregRoots, error) {
+		var st verifreg5.State
+		err := cst.Get(ctx, head, &st)
+		return verifregRoots{st.Verifiers, st.VerifiedClients}, err
+	}},
+}
+
+// verifregActorVersion finds the actor version of a verified registry code CID, false for ones this build doesn't know
+func verifregActorVersion(code cid.Cid) (int, bool) {
+	for version, schema := range verifregSchemas {
+		if schema.code.Equals(code) {
+			return version, true
+		}
+	}
+	return 0, false
+}
+
+// decodeVerifregState decodes the verified registry state with the schema for VERIFREG_STATE_VERSION, or for the
+// deployed actor's version when that's 0. After a network upgrade the deployed actor can be newer than this build,
+// so failures say which schema was tried against which actor rather than just that CBOR didn't decode.
+func decodeVerifregState(ctx context.Context, cst cbor.IpldStore, act *types.Actor) (verifregRoots, error) {
+	deployed, known := verifregActorVersion(act.Code)
+	version := env.VerifregStateVersion
+	if version == 0 && !known {
+		return verifregRoots{}, errors.Errorf("verified registry actor code %s isn't a version this build supports, set VERIFREG_STATE_VERSION to a compatible schema", act.Code)
+	} else if version == 0 {
+		version = deployed
+	}
+
+	schema, ok := verifregSchemas[version]
+	if !ok {
+		return verifregRoots{}, errors.Errorf("VERIFREG_STATE_VERSION=%d isn't a supported verified registry schema", version)
+	}
+
+	roots, err := schema.decode(ctx, cst, act.Head)
+	if err != nil && known {
+		return verifregRoots{}, errors.Wrapf(err, "decoding verified registry state %s with the v%d schema, the deployed actor is v%d", act.Head, version, deployed)
+	} else if err != nil {
+		return verifregRoots{}, errors.Wrapf(err, "decoding verified registry state %s with the v%d schema, the deployed actor code %s is unknown", act.Head, version, act.Code)
+	}
+	return roots, nil
+}
+
+// checkVerifregState logs once at startup whether the deployed verified registry state decodes, since when it
+// doesn't every DataCap endpoint fails at once
+func checkVerifregState() {
+	ctx, cancel := context.WithTimeout(backgroundCtx, 30*time.Second)
+	defer cancel()
+
+	api, closer, err := lotusGetFullNodeAPI(ctx)
+	if err != nil {
+		log.Println("error checking verified registry state:", err)
+		return
+	}
+	defer closer()
+
+	act, err := api.StateGetActor(ctx, builtin4.VerifiedRegistryActorAddr, types.EmptyTSK)
+	if err != nil {
+		log.Println("error checking verified registry state:", err)
+		return
+	}
+
+	cst := cbor.NewCborStore(apibstore.NewAPIBlockstore(api))
+	if _, err := decodeVerifregState(ctx, cst, act); err != nil {
+		log.Println("VERIFIED REGISTRY STATE DOES NOT DECODE, verifier and client listings will fail:", err)
+		return
+	}
+	if version, known := verifregActorVersion(act.Code); known {
+		log.Printf("verified registry state decodes, deployed actor is v%d\n", version)
+	} else {
+		log.Printf("verified registry state decodes with the v%d schema, deployed actor code %s is unknown\n", env.VerifregStateVersion, act.Code)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+type verifyCheckMode int
+
+const (
+	// verifyCheckSubmit stops at the first refusal and raises the slack alerts, for serveVerifyAccount
+	verifyCheckSubmit verifyCheckMode = iota
+	// verifyCheckQuote runs every check quietly, for serveVerifyQuote
+	verifyCheckQuote
+)
+
+// verifyRefusal is a failed check, with the response serveVerifyAccount sends for it
+type verifyRefusal struct {
+	Status int
+	Body   gin.H
+}
+
+func (r verifyRefusal) message() string {
+	msg, _ := r.Body["error"].(string)
+	return msg
+}
+
+// verifyPlan is what checkVerifyRequest worked out the request would allocate
+type verifyPlan struct {
+	TargetAddr      address.Address
+	ClientRemaining types.BigInt
+	Owed            types.BigInt
+	// Allowance is zero when the client is already at or over the cap
+	Allowance types.BigInt
+	Partial   bool
+	// NextEligibleAt is the latest of the cooldowns holding the request back, nil if none are
+	NextEligibleAt *time.Time
+	Refusals       []verifyRefusal
+}
+
+func (plan *verifyPlan) holdUntil(at *time.Time) {
+	if at != nil && (plan.NextEligibleAt == nil || at.After(*plan.NextEligibleAt)) {
+		plan.NextEligibleAt = at
+	}
+}
+
+// checkVerifyRequest runs the eligibility checks and allowance math of a verify for the user and target, without
+// locking, counting or sending anything. In verifyCheckSubmit mode the first refusal is returned, in
+// verifyCheckQuote every check runs and the refusals are collected on the plan. Either way a check that couldn't
+// run at all is returned with the response for it.
+func checkVerifyRequest(ctx context.Context, c *gin.Context, user User, targetAddrStr string, mode verifyCheckMode) (verifyPlan, *verifyRefusal) {
+	plan := verifyPlan{Allowance: types.NewInt(0)}
+	notify := mode == verifyCheckSubmit
+	refuse := func(status int, body gin.H) *verifyRefusal {
+		refusal := verifyRefusal{Status: status, Body: body}
+		plan.Refusals = append(plan.Refusals, refusal)
+		if mode == verifyCheckSubmit {
+			return &refusal
+		}
+		return nil
+	}
+	failed := func(status int, err error) (verifyPlan, *verifyRefusal) {
+		return plan, &verifyRefusal{Status: status, Body: gin.H{"error": err.Error()}}
+	}
+
+	targetAddr, err := address.NewFromString(targetAddrStr)
+	if err != nil {
+		return failed(http.StatusBadRequest, err)
+	}
+	plan.TargetAddr = targetAddr
+
+	if user.HasTag(UserTag_Blocked) {
+		if r := refuse(http.StatusForbidden, gin.H{"error": ErrUserBlocked.Error(), "failedCheck": "user_blocked"}); r != nil {
+			return plan, r
+		}
+	}
+
+	if user.IsLocked(UserLock_Verifier) {
+		if r := refuse(http.StatusForbidden, gin.H{"error": ErrUserLocked.Error(), "failedCheck": "user_locked"}); r != nil {
+			return plan, r
+		}
+	}
+
+	// No account less than MinAccountAge is allowed any FIL
+	minAccountAge := time.Duration(env.VerifierMinAccountAgeDays) * 24 * time.Hour
+	if !user.MeetsMinAccountAge(minAccountAge) {
+		if notify {
+			slackNotification := "Requester's ID:" + user.ID + " Requester's FIL address: " + targetAddrStr + "\nRequester's GH Handle: " + user.Accounts["github"].Username + "\nRequester's Account age: " + user.Accounts["github"].CreatedAt.String() + "\n----------"
+			sendSlackNotification("https://errors.glif.io/verifier-account-too-young", slackNotification)
+		}
+		if r := refuse(http.StatusForbidden, gin.H{"error": ErrUserTooNew.Error(), "failedCheck": "account_age"}); r != nil {
+			return plan, r
+		}
+	}
+
+	pending, err := hasPendingReview(user.ID)
+	if err != nil {
+		return failed(http.StatusInternalServerError, err)
+	} else if pending {
+		if r := refuse(http.StatusConflict, gin.H{"error": ErrReviewPending.Error(), "failedCheck": "pending_review"}); r != nil {
+			return plan, r
+		}
+	}
+
+	if !user.MeetsProviderMetadataRequirements() {
+		if r := refuse(http.StatusForbidden, gin.H{"error": ErrInsufficientSignals.Error(), "failedCheck": "provider_signals"}); r != nil {
+			return plan, r
+		}
+	}
+
+	if domains := allowedEmailDomains(); domains != nil && !user.HasVerifiedEmailInDomains(domains) {
+		if r := refuse(http.StatusForbidden, gin.H{"error": ErrEmailNotAllowed.Error(), "failedCheck": "email_domain"}); r != nil {
+			return plan, r
+		}
+	}
+
+	if !meetsMinTrustScore(user) {
+		if r := refuse(http.StatusForbidden, gin.H{"error": ErrTrustScoreTooLow.Error(), "failedCheck": "trust_score"}); r != nil {
+			return plan, r
+		}
+	}
+
+	// Ensure that the user hasn't asked for more allocation too recently
+	lastAllocated, err := lastAllocation(ctx, user, targetAddrStr)
+	if err != nil {
+		return failed(http.StatusInternalServerError, err)
+	}
+	if nextEligible := lastAllocated.Add(settings().VerifierRateLimit); nextEligible.After(time.Now()) {
+		if notify {
+			slackNotification := "Requester's ID:" + user.ID + "Requester's FIL address: " + targetAddrStr + "\nRequester's GH Handle: " + user.Accounts["github"].Username + "\nRequester's Most recent allocation: " + lastAllocated.String() + "\n----------"
+			sendSlackNotification("https://errors.glif.io/verifier-reallocation-too-soon", slackNotification)
+		}
+		plan.holdUntil(&nextEligible)
+		if r := refuse(http.StatusForbidden, gin.H{"error": ErrAllocatedTooRecently.Error(), "failedCheck": "reallocation_cooldown", "nextEligibleAt": nextEligible}); r != nil {
+			return plan, r
+		}
+	}
+
+	// A new address is held to the address change policy, so the cooldown can't reset onto a fresh address every time
+	if nextEligible, err := user.checkAddressChange(targetAddrStr); err != nil {
+		plan.holdUntil(nextEligible)
+		if r := refuse(http.StatusForbidden, gin.H{"error": err.Error(), "failedCheck": "address_change", "nextEligibleAt": nextEligible}); r != nil {
+			return plan, r
+		}
+	}
+
+	reachedCount, err := reachedCounter(c)
+	if reachedCount {
+		if notify {
+			slackNotification := "VERIFIER COUNTER REACHED: " + fmt.Sprint(settings().MaxTotalAllocations)
+			sendSlackNotification("https://errors.glif.io/verifier-counter-reached", slackNotification)
+		}
+		if r := refuse(http.StatusLocked, gin.H{"error": ErrCounterReached.Error(), "failedCheck": "allocation_counter"}); r != nil {
+			return plan, r
+		}
+	} else if err != nil {
+		if notify {
+			slackNotification := "VERIFIER COUNTER CALCULATION FAILED: " + fmt.Sprint(settings().MaxTotalAllocations) + err.Error()
+			sendSlackNotification("https://errors.glif.io/verifier-counter-reached", slackNotification)
+		}
+		return failed(http.StatusInternalServerError, ErrCounterReached)
+	}
+
+	dataCap, err := lotusCheckVerifierRemainingBytes(ctx, VerifierAddr.String())
+	if err != nil {
+		if notify {
+			slackNotification := "LOTUS CHECK VERIFIER BYTES FAILED" + err.Error() + "\n----------"
+			sendSlackNotification("https://errors.glif.io/verifier-tx-failed", slackNotification)
+		}
+		return failed(http.StatusLocked, ErrCounterReached)
+	}
+	fiftyDataCaps := types.BigMul(env.MaxAllowanceBytes, types.NewInt(50))
+	available := verifierAvailableBytes(dataCap)
+
+	if notify && available.LessThanEqual(fiftyDataCaps) {
+		slackNotification := "LOW DATA CAP: " + dataCap.String() + " (available after reserve: " + available.String() + ")"
+		sendSlackNotification("https://errors.glif.io/verifier-low-data-cap", slackNotification)
+	}
+
+	// Only top the client up to the cap, refusing when they're already there
+	clientRemaining, err := lotusCheckAccountRemainingBytes(ctx, targetAddrStr)
+	if err != nil {
+		return failed(http.StatusBadRequest, err)
+	}
+	plan.ClientRemaining = clientRemaining
+	plan.Owed = types.BigSub(env.MaxAllowanceBytes, clientRemaining)
+	if plan.Owed.LessThan(types.NewInt(0)) {
+		if r := refuse(http.StatusForbidden, gin.H{
+			"error":             env.OverCapMessage,
+			"code":              "OVER_CAP",
+			"failedCheck":       "over_cap",
+			"remainingBytes":    clientRemaining.String(),
+			"maxAllowanceBytes": env.MaxAllowanceBytes.String(),
+		}); r != nil {
+			return plan, r
+		}
+	} else if plan.Owed.IsZero() {
+		if r := refuse(http.StatusForbidden, gin.H{
+			"error":             env.AlreadyVerifiedMessage,
+			"code":              "ALREADY_VERIFIED",
+			"failedCheck":       "already_verified",
+			"remainingBytes":    clientRemaining.String(),
+			"maxAllowanceBytes": env.MaxAllowanceBytes.String(),
+		}); r != nil {
+			return plan, r
+		}
+	} else {
+		if minimum := minAllocationBytes(); plan.Owed.LessThan(minimum) {
+			if r := refuse(http.StatusForbidden, gin.H{
+				"error":              ErrAllocationTooSmall.Error(),
+				"failedCheck":        "min_allocation",
+				"remainingBytes":     clientRemaining.String(),
+				"minAllocationBytes": minimum.String(),
+			}); r != nil {
+				return plan, r
+			}
+		}
+
+		// Cap the allocation at what the verifier has available, or refuse it when partial allocations are disabled
+		plan.Allowance = plan.Owed
+		if available.LessThan(plan.Allowance) {
+			if !env.AllowPartialAllocation || available.IsZero() {
+				if r := refuse(http.StatusLocked, gin.H{"error": ErrVerifierOutOfDataCap.Error(), "failedCheck": "verifier_insufficient"}); r != nil {
+					return plan, r
+				}
+			}
+			plan.Allowance = available
+			plan.Partial = true
+		}
+
+		// Keep the user within their rolling window cap, reducing the allocation the same way as above
+		if !settings().MaxAllowancePerWindow.IsZero() {
+			used, err := windowAllowanceUsed(user.ID)
+			if err != nil {
+				return failed(http.StatusInternalServerError, err)
+			}
+			windowRemaining := types.BigSub(settings().MaxAllowancePerWindow, used)
+			if windowRemaining.LessThan(plan.Allowance) {
+				if !env.AllowPartialAllocation || windowRemaining.LessThanEqual(types.NewInt(0)) {
+					if r := refuse(http.StatusForbidden, gin.H{"error": ErrWindowCapReached.Error(), "failedCheck": "window_cap"}); r != nil {
+						return plan, r
+					}
+				}
+				plan.Allowance = windowRemaining
+				plan.Partial = true
+			}
+		}
+		if plan.Allowance.LessThan(types.NewInt(0)) {
+			plan.Allowance = types.NewInt(0)
+		}
+	}
+
+	if isAddressBlocked(targetAddr) {
+		if r := refuse(http.StatusForbidden, gin.H{"error": ErrAddressBlocked.Error(), "failedCheck": "address_blocked"}); r != nil {
+			return plan, r
+		}
+	}
+
+	// Pubkey addresses can be verified before they're on chain, the actor is created when the DataCap arrives
+	_, onChain, err := lotusCanonicalAddress(ctx, targetAddr)
+	if err != nil && errors.Cause(err) == ErrAddressNotOnChain {
+		if r := refuse(http.StatusBadRequest, gin.H{"error": ErrAddressNotOnChain.Error(), "failedCheck": "address_not_on_chain"}); r != nil {
+			return plan, r
+		}
+	} else if err != nil {
+		return failed(http.StatusInternalServerError, err)
+	} else if !onChain && !env.AllowNewActorTargets {
+		if r := refuse(http.StatusBadRequest, gin.H{"error": ErrAddressNotOnChain.Error(), "failedCheck": "address_not_on_chain"}); r != nil {
+			return plan, r
+		}
+	}
+
+	// DataCap sent to a notary is almost certainly a mistake, an address not on chain can't be one
+	if onChain {
+		isVerifier, err := lotusIsVerifier(ctx, targetAddr)
+		if err != nil {
+			return failed(http.StatusInternalServerError, err)
+		} else if isVerifier && env.RejectVerifierTargets {
+			if r := refuse(http.StatusBadRequest, gin.H{"error": ErrTargetIsVerifier.Error(), "failedCheck": "target_is_verifier"}); r != nil {
+				return plan, r
+			}
+		} else if isVerifier && notify {
+			slackNotification := "VERIFY TARGET IS A NOTARY: " + targetAddrStr + "\nRequester's ID: " + user.ID + "\n----------"
+			sendSlackNotification("https://errors.glif.io/verifier-target-is-notary", slackNotification)
+		}
+	}
+
+	if meets, balance, err := meetsMinTargetBalance(ctx, targetAddr, onChain); err != nil {
+		return failed(http.StatusInternalServerError, err)
+	} else if !meets {
+		if r := refuse(http.StatusForbidden, gin.H{
+			"error":            ErrTargetBalanceTooLow.Error(),
+			"failedCheck":      "target_balance",
+			"balance":          balance.String(),
+			"minTargetBalance": env.MinTargetBalance.String(),
+		}); r != nil {
+			return plan, r
+		}
+	}
+
+	return plan, nil
+}
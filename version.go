@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// set at build time with -ldflags "-X main.version=... -X main.commit=..."
+var (
+	version = "dev"
+	commit  = "unknown"
+)
+
+const nodeVersionCacheTTL = time.Minute
+
+type nodeVersion struct {
+	Version string `json:"version"`
+	Network string `json:"network"`
+}
+
+var (
+	cachedNodeVersion   nodeVersion
+	cachedNodeVersionAt time.Time
+	cachedNodeVersionMu sync.Mutex
+)
+
+// lotusNodeVersion returns the connected node's version, cached briefly so /version doesn't hit the node every call
+func lotusNodeVersion(ctx context.Context) (nodeVersion, error) {
+	cachedNodeVersionMu.Lock()
+	defer cachedNodeVersionMu.Unlock()
+
+	if time.Since(cachedNodeVersionAt) < nodeVersionCacheTTL {
+		return cachedNodeVersion, nil
+	}
+
+	api, closer, err := lotusGetFullNodeAPI(ctx)
+	if err != nil {
+		return nodeVersion{}, err
+	}
+	defer closer()
+
+	v, err := api.Version(ctx)
+	if err != nil {
+		return nodeVersion{}, err
+	}
+
+	network, err := api.StateNetworkName(ctx)
+	if err != nil {
+		return nodeVersion{}, err
+	}
+
+	cachedNodeVersion = nodeVersion{Version: v.Version, Network: string(network)}
+	cachedNodeVersionAt = time.Now()
+	return cachedNodeVersion, nil
+}
+
+func serveVersion(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(tracedBackground(c), 10*time.Second)
+	defer cancel()
+
+	type Response struct {
+		Version string       `json:"version"`
+		Commit  string       `json:"commit"`
+		Mode    Mode         `json:"mode"`
+		Node    *nodeVersion `json:"node,omitempty"`
+		NodeErr string       `json:"nodeError,omitempty"`
+	}
+	resp := Response{Version: version, Commit: commit, Mode: env.Mode}
+
+	// still report our own version when the node is unreachable, that's when this is most useful
+	node, err := lotusNodeVersion(ctx)
+	if err != nil {
+		resp.NodeErr = err.Error()
+	} else {
+		resp.Node = &node
+	}
+	c.JSON(http.StatusOK, resp)
+}
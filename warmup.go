@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// On startup warmUp checks the node connection and the verifier, and primes the state cache, before mutating
+// routes are let through. Reads are served throughout. After WARM_UP_TIMEOUT the server is marked ready
+// whatever the checks said, a failed check is reported on /health rather than keeping the deploy out forever.
+
+// how long a load balancer should wait before retrying a request turned away while warming up
+const warmUpRetryAfter = 5 * time.Second
+
+var warmedUp int32
+
+var (
+	warmUpResults   = make(map[string]string)
+	warmUpResultsMu sync.Mutex
+)
+
+type warmUpCheck struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+func warmUpChecks() []warmUpCheck {
+	checks := []warmUpCheck{
+		{"node", func(ctx context.Context) error {
+			_, err := lotusChainHead(ctx)
+			return err
+		}},
+	}
+	if env.Mode != FaucetMode {
+		checks = append(checks, warmUpCheck{"verifier", func(ctx context.Context) error {
+			isVerifier, err := lotusIsVerifier(ctx, VerifierAddr)
+			if err != nil {
+				return err
+			}
+			// not worth retrying, verify requests will fail on chain until it's fixed
+			if !isVerifier {
+				log.Println("WARNING: verifier", VerifierAddr, "isn't a notary on chain")
+			}
+			return nil
+		}})
+		if env.StateCacheTTL > 0 {
+			checks = append(checks, warmUpCheck{"state-cache", func(ctx context.Context) error {
+				_, err := lotusListVerifiers(ctx)
+				return err
+			}})
+		}
+	}
+	return checks
+}
+
+func isWarmedUp() bool {
+	return atomic.LoadInt32(&warmedUp) == 1
+}
+
+// warmUp runs the checks in order, retrying each until it passes or WARM_UP_TIMEOUT runs out
+func warmUp() {
+	defer atomic.StoreInt32(&warmedUp, 1)
+	if env.WarmUpTimeout == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(backgroundCtx, env.WarmUpTimeout)
+	defer cancel()
+
+	start := time.Now()
+	for _, check := range warmUpChecks() {
+		err := retry(ctx, func() error {
+			return check.Run(ctx)
+		})
+		// retry returns nil if the timeout ran out before the check ever ran
+		if err == nil {
+			err = ctx.Err()
+		}
+
+		result := "ok"
+		if err != nil {
+			result = err.Error()
+			log.Println("warm-up check", check.Name, "failed:", err)
+		}
+		warmUpResultsMu.Lock()
+		warmUpResults[check.Name] = result
+		warmUpResultsMu.Unlock()
+	}
+	log.Println("warmed up in", time.Since(start))
+}
+
+func warmUpStatus() map[string]string {
+	warmUpResultsMu.Lock()
+	defer warmUpResultsMu.Unlock()
+	status := make(map[string]string, len(warmUpResults))
+	for name, result := range warmUpResults {
+		status[name] = result
+	}
+	return status
+}
+
+// requireWarmedUp turns mutating requests away with a 503 until warmUp is done
+func requireWarmedUp(c *gin.Context) {
+	if !isWarmedUp() {
+		respondUnavailable(c, ErrWarmingUp, warmUpRetryAfter)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// serveReadiness is the readiness probe, /healthz stays the liveness probe and answers as soon as the process is up
+func serveReadiness(c *gin.Context) {
+	if !isWarmedUp() {
+		setRetryAfter(c, warmUpRetryAfter)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "warming up"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}